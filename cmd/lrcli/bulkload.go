@@ -32,10 +32,12 @@ import (
 )
 
 type entry struct {
-	ID    string    `json:"id"`
-	Title string    `json:"title"`
-	Text  string    `json:"text"`
-	Date  time.Time `json:"date"`
+	ID     string              `json:"id"`
+	Title  string              `json:"title"`
+	Text   string              `json:"text"`
+	Date   time.Time           `json:"date"`
+	Boost  float32             `json:"boost"`
+	Fields map[string][]string `json:"fields"`
 }
 
 type bulkLoadOptions struct {
@@ -122,6 +124,8 @@ func bulkLoad(db letarette.Database, options bulkLoadOptions, shardGroupSize int
 				Text:    e.Text,
 				Alive:   true,
 				Updated: e.Date,
+				Boost:   e.Boost,
+				Fields:  e.Fields,
 			}
 			err = loader.Load(doc)
 			if err != nil {
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
 	"text/tabwriter"
@@ -297,3 +298,51 @@ func updateSpelling(cfg letarette.Config, minCount int) {
 	}
 	s.Stop("OK\n")
 }
+
+type weightOptions struct {
+	databaseOptions
+	Space   string `arg:"0"`
+	Weights string `arg:"1"`
+}
+
+func doWeights(cfg letarette.Config, options weightOptions) {
+	db, err := letarette.OpenDatabase(cfg)
+	if err != nil {
+		logger.Error.Printf("Failed to open db: %v", err)
+		return
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	if options.Weights != "" {
+		var weights letarette.RankWeights
+		err = weights.Decode(options.Weights)
+		if err == nil {
+			err = letarette.SetRankWeights(ctx, db, options.Space, weights)
+		}
+		if err != nil {
+			logger.Error.Printf("Failed to set rank weights: %v", err)
+			return
+		}
+	}
+
+	allWeights, err := letarette.GetRankWeights(ctx, db)
+	if err != nil {
+		logger.Error.Printf("Failed to get rank weights: %v", err)
+		return
+	}
+	tabs := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	fmt.Fprintf(tabs, "SPACE\tTITLE/TEXT\n")
+	spaces := []string{}
+	for space := range allWeights {
+		if options.Space == "" || options.Space == space {
+			spaces = append(spaces, space)
+		}
+	}
+	sort.Strings(spaces)
+	for _, space := range spaces {
+		fmt.Fprintf(tabs, "%s\t%v\n", space, allWeights[space])
+	}
+	tabs.Flush()
+}
@@ -39,7 +39,9 @@ func usage() {
 	usage := `Letarette
 
 Usage:
-    lrcli search [-l <limit>] [-p <page>] [-g <groupsize>] [-i] <space> [<phrase>...]
+    lrcli search [-l <limit>] [-p <page>] [-g <groupsize>] [-M <mode>] [-n <distance>] [-w <within>] [-s <sort>] [-c <cursor>] [-f <filters>] [-F <facets>] [-S <snippet>] [-T <tokens>] [-N <fragments>] [-R <docID>] [-H] [-z] [-x] [-P] [-i] <space> [<phrase>...]
+    lrcli suggest [-l <limit>] [-g <groupsize>] <prefix> [<space>...]
+    lrcli get [-g <groupsize>] <space> <docID>
     lrcli monitor
     lrcli sql [-d <db>] <sql> [<arg>...]
     lrcli index [-d <db>] stats
@@ -52,14 +54,30 @@ Usage:
     lrcli load [-d <db>] [-m <max>] [-a] <space> <json>
     lrcli synonyms [-d <db>] [<json>]
     lrcli spelling [-d <db>] update <mincount>
+    lrcli weights [-d <db>] [<space> [<title>/<text>]]
     lrcli resetmigration [-d <db>] <version>
     lrcli env [-v]
 
 Options:
     -l <limit>     Search result page limit [default: 10]
     -p <page>      Search result page [default: 0]
+    -M <mode>      Search match mode, near, all or any [default: near]
+    -n <distance>  Max term distance, implies near mode [default: 15]
+    -w <within>    Only match documents updated within duration, like 720h
+    -s <sort>      Search hit order, rank, date or recency [default: rank]
+    -S <snippet>   Search hit snippet, text, title or none [default: text]
+    -T <tokens>    Tokens per text snippet fragment [default: 10]
+    -N <fragments> Max number of text snippet fragments [default: 1]
+    -c <cursor>    Continue after search cursor, "start" starts a cursor walk
+    -f <filters>   Semicolon separated field filters, like "tag=news;author in (a, b)"
+    -F <facets>    Comma separated facet fields, "@space" counts hits per space
+    -R <docID>     Search for documents similar to a document in <space>
     -d <db>        Override default or environment DB path
     -i             Interactive search
+    -H             Highlight matches in search hits
+    -z             Fuzzy search, match all terms as if suffixed by "~"
+    -x             Explain search, print search diagnostics
+    -P             Return partial search results on shard timeout
     -a             Auto-assign document ID on load
     -m <max>       Max documents loaded
     -g <groupsize> Force shard group size, do not discover
@@ -99,6 +117,18 @@ func main() {
 			pennant.MustParse(&options, args)
 			doSearch(cfg, options)
 		}
+	case "suggest":
+		{
+			var options suggestOptions
+			pennant.MustParse(&options, args)
+			doSuggest(cfg, options)
+		}
+	case "get":
+		{
+			var options getOptions
+			pennant.MustParse(&options, args)
+			doGet(cfg, options)
+		}
 	case "env":
 		{
 			var options globalOptions
@@ -146,6 +176,13 @@ func main() {
 			}
 			updateSpelling(cfg, options.MinCount)
 		}
+	case "weights":
+		{
+			var options weightOptions
+			pennant.MustParse(&options, args)
+			updateFromFromOptions(&options.databaseOptions)
+			doWeights(cfg, options)
+		}
 
 	case "resetmigration":
 		{
@@ -18,6 +18,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -28,12 +29,45 @@ import (
 )
 
 type searchOptions struct {
-	Space       string   `arg:"0"`
-	Phrases     []string `args:"1"`
-	Limit       int      `name:"l" default:"10"`
-	Offset      int      `name:"p" default:"0"`
-	GroupSize   int32    `name:"g"`
-	Interactive bool     `name:"i"`
+	Space       string        `arg:"0"`
+	Phrases     []string      `args:"1"`
+	Limit       int           `name:"l" default:"10"`
+	Offset      int           `name:"p" default:"0"`
+	GroupSize   int32         `name:"g"`
+	Interactive bool          `name:"i"`
+	MatchMode   string        `name:"M" default:"near"`
+	Distance    uint16        `name:"n"`
+	Within      time.Duration `name:"w"`
+	Sort        string        `name:"s" default:"rank"`
+	Cursor      string        `name:"c"`
+	Filters     string        `name:"f"`
+	Facets      string        `name:"F"`
+	Explain     bool          `name:"x"`
+	Highlight   bool          `name:"H"`
+	Fuzzy       bool          `name:"z"`
+	Snippet     string        `name:"S" default:"text"`
+	Tokens      uint16        `name:"T" default:"10"`
+	Fragments   uint8         `name:"N" default:"1"`
+	Similar     string        `name:"R"`
+	Partial     bool          `name:"P"`
+}
+
+var sortOrders = map[string]protocol.SortOrder{
+	protocol.SortByRank.String():    protocol.SortByRank,
+	protocol.SortByDate.String():    protocol.SortByDate,
+	protocol.SortByRecency.String(): protocol.SortByRecency,
+}
+
+var snippetModes = map[string]protocol.SnippetMode{
+	protocol.SnippetText.String():  protocol.SnippetText,
+	protocol.SnippetTitle.String(): protocol.SnippetTitle,
+	protocol.SnippetNone.String():  protocol.SnippetNone,
+}
+
+var matchModes = map[string]protocol.MatchMode{
+	protocol.MatchProximity.String(): protocol.MatchProximity,
+	protocol.MatchAll.String():       protocol.MatchAll,
+	protocol.MatchAny.String():       protocol.MatchAny,
 }
 
 func doSearch(cfg letarette.Config, options searchOptions) {
@@ -41,14 +75,29 @@ func doSearch(cfg letarette.Config, options searchOptions) {
 		fmt.Println("Expected <space> arg")
 		return
 	}
+	if _, ok := matchModes[options.MatchMode]; !ok {
+		fmt.Printf("Unknown match mode %q\n", options.MatchMode)
+		return
+	}
+	if _, ok := sortOrders[options.Sort]; !ok {
+		fmt.Printf("Unknown sort order %q\n", options.Sort)
+		return
+	}
+	if _, ok := snippetModes[options.Snippet]; !ok {
+		fmt.Printf("Unknown snippet mode %q\n", options.Snippet)
+		return
+	}
 	fmt.Printf("Searching space %q\n", options.Space)
-	a, err := client.NewSearchAgent(
-		cfg.Nats.URLS,
+	agentOptions := []client.Option{
 		client.WithSeedFile(cfg.Nats.SeedFile),
 		client.WithShardgroupSize(options.GroupSize),
 		client.WithRootCAs(cfg.Nats.RootCAs...),
-		client.WithTimeout(10*time.Second),
-	)
+		client.WithTimeout(10 * time.Second),
+	}
+	if options.Partial {
+		agentOptions = append(agentOptions, client.WithPartialResults())
+	}
+	a, err := client.NewSearchAgent(cfg.Nats.URLS, agentOptions...)
 	if err != nil {
 		logger.Error.Printf("Failed to create search agent: %v", err)
 		return
@@ -69,12 +118,60 @@ func doSearch(cfg letarette.Config, options searchOptions) {
 }
 
 func searchPhrase(phrase string, agent client.SearchAgent, options searchOptions) {
-	res, err := agent.Search(
-		phrase,
-		[]string{options.Space},
-		options.Limit,
-		options.Offset,
-	)
+	mode := client.WithMatchMode(matchModes[options.MatchMode])
+	if options.Distance != 0 {
+		mode = client.WithProximity(options.Distance)
+	}
+	searchOptions := []client.SearchOption{
+		mode,
+		client.WithUpdatedWithin(options.Within),
+		client.WithSort(sortOrders[options.Sort]),
+		client.WithCursor(options.Cursor),
+		client.WithSnippet(snippetModes[options.Snippet]),
+		client.WithSnippetSize(options.Tokens, options.Fragments),
+	}
+	if options.Filters != "" {
+		searchOptions = append(searchOptions, client.WithFilters(strings.Split(options.Filters, ";")...))
+	}
+	if options.Facets != "" {
+		for _, facet := range strings.Split(options.Facets, ",") {
+			if facet == spaceFacet {
+				searchOptions = append(searchOptions, client.WithSpaceFacets())
+			} else {
+				searchOptions = append(searchOptions, client.WithFieldFacets(facet))
+			}
+		}
+	}
+	if options.Highlight {
+		// Bold and reset terminal escape sequences
+		searchOptions = append(searchOptions, client.WithHighlight("\x1b[1m", "\x1b[0m"))
+	}
+	if options.Fuzzy {
+		searchOptions = append(searchOptions, client.WithFuzzy())
+	}
+	if options.Explain {
+		searchOptions = append(searchOptions, client.WithExplain())
+	}
+	var res protocol.SearchResponse
+	var err error
+	if options.Similar != "" {
+		res, err = agent.SearchSimilar(
+			options.Space,
+			protocol.DocumentID(options.Similar),
+			[]string{options.Space},
+			options.Limit,
+			options.Offset,
+			searchOptions...,
+		)
+	} else {
+		res, err = agent.Search(
+			phrase,
+			[]string{options.Space},
+			options.Limit,
+			options.Offset,
+			searchOptions...,
+		)
+	}
 	if err != nil {
 		logger.Error.Printf("Failed to perform search: %v", err)
 		return
@@ -83,11 +180,166 @@ func searchPhrase(phrase string, agent client.SearchAgent, options searchOptions
 	fmt.Printf("Query executed in %v seconds with status %q\n", res.Duration, res.Status.String())
 	fmt.Printf("Returning %v of %v total hits, capped: %v\n",
 		len(res.Result.Hits), res.Result.TotalHits, res.Result.Capped)
-	if res.Status == protocol.SearchStatusNoHit && res.Result.Respelt != "" {
-		fmt.Printf("Did you mean %s?\n", res.Result.Respelt)
+	if res.Result.Partial {
+		fmt.Println("Partial results, not all shards responded:")
+		for _, shard := range res.Shards {
+			var status string
+			if !shard.Responded {
+				status = "no response"
+			} else {
+				status = shard.Status.String()
+			}
+			if shard.Error != "" {
+				status += ": " + shard.Error
+			}
+			fmt.Printf("  %s %s\n", shard.Shard, status)
+		}
+	}
+	for _, correction := range res.Result.Corrections {
+		fmt.Printf("Did you mean %s? (%d hits)\n", correction.Query, correction.Hits)
+	}
+	if options.Cursor != "" && res.Result.Cursor != "" {
+		fmt.Printf("Next page cursor: %s\n", res.Result.Cursor)
 	}
 	fmt.Println()
 	for _, doc := range res.Result.Hits {
 		fmt.Printf("[%v] %s\n", doc.ID, doc.Snippet)
 	}
+	printFacets(res.Result.Facets)
+	for _, explanation := range res.Explanations {
+		printExplanation(explanation)
+	}
+}
+
+// spaceFacet is the facet option name for hit counts per space
+const spaceFacet = "@space"
+
+func printFacets(facets protocol.SearchFacets) {
+	printCounts := func(name string, counts map[string]int) {
+		fmt.Printf("\nFacet %s:\n", name)
+		values := make([]string, 0, len(counts))
+		for value := range counts {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if counts[values[i]] != counts[values[j]] {
+				return counts[values[i]] > counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		for _, value := range values {
+			fmt.Printf("  %-24s %d\n", value+":", counts[value])
+		}
+	}
+	if facets.Spaces != nil {
+		printCounts(spaceFacet, facets.Spaces)
+	}
+	for field, counts := range facets.Fields {
+		printCounts(field, counts)
+	}
+}
+
+func printExplanation(explanation protocol.SearchExplanation) {
+	fmt.Printf("\nShard %s:\n", explanation.Shard)
+	fmt.Printf("  Parsed:    %s\n", strings.Join(explanation.Parsed, " "))
+	fmt.Printf("  Reduced:   %s\n", strings.Join(explanation.Reduced, " "))
+	fmt.Printf("  Canonical: %s\n", explanation.Canonical)
+	fmt.Printf("  Stopwords: %s\n", strings.Join(explanation.Stopwords, " "))
+	for term, synonyms := range explanation.Synonyms {
+		fmt.Printf("  Synonyms:  %s -> %s\n", term, strings.Join(synonyms, " "))
+	}
+	for term, neighbours := range explanation.FuzzyTerms {
+		fmt.Printf("  Fuzzy:     %s -> %s\n", term, strings.Join(neighbours, " "))
+	}
+	for from, to := range explanation.SpellFixes {
+		fmt.Printf("  Respelt:   %s -> %s\n", from, to)
+	}
+	if explanation.Autocorrected {
+		fmt.Printf("  Autocorrected\n")
+	}
+	fmt.Printf("  Match:     %s\n", explanation.Match)
+	fmt.Printf("  Strategy:  %d\n", explanation.Strategy)
+	for _, timing := range explanation.Timings {
+		fmt.Printf("  %-24s %.6fs\n", timing.Stage+":", timing.Duration)
+	}
+}
+
+type suggestOptions struct {
+	Prefix    string   `arg:"0"`
+	Spaces    []string `args:"1"`
+	Limit     int      `name:"l" default:"10"`
+	GroupSize int32    `name:"g"`
+}
+
+func doSuggest(cfg letarette.Config, options suggestOptions) {
+	if len(options.Prefix) == 0 {
+		fmt.Println("Expected <prefix> arg")
+		return
+	}
+	a, err := client.NewSuggestAgent(
+		cfg.Nats.URLS,
+		client.WithSeedFile(cfg.Nats.SeedFile),
+		client.WithShardgroupSize(options.GroupSize),
+		client.WithRootCAs(cfg.Nats.RootCAs...),
+		client.WithTimeout(10*time.Second),
+	)
+	if err != nil {
+		logger.Error.Printf("Failed to create suggest agent: %v", err)
+		return
+	}
+	defer a.Close()
+
+	res, err := a.Suggest(options.Prefix, options.Spaces, options.Limit)
+	if err != nil {
+		logger.Error.Printf("Failed to get suggestions: %v", err)
+		return
+	}
+	fmt.Printf("Suggestions fetched in %v seconds with status %q\n", res.Duration, res.Status.String())
+	for _, suggestion := range res.Suggestions {
+		fmt.Printf("%-24s %d\n", suggestion.Term, suggestion.Count)
+	}
+}
+
+type getOptions struct {
+	Space     string `arg:"0"`
+	ID        string `arg:"1"`
+	GroupSize int32  `name:"g"`
+}
+
+func doGet(cfg letarette.Config, options getOptions) {
+	if len(options.Space) == 0 || len(options.ID) == 0 {
+		fmt.Println("Expected <space> and <docID> args")
+		return
+	}
+	a, err := client.NewSearchAgent(
+		cfg.Nats.URLS,
+		client.WithSeedFile(cfg.Nats.SeedFile),
+		client.WithShardgroupSize(options.GroupSize),
+		client.WithRootCAs(cfg.Nats.RootCAs...),
+		client.WithTimeout(10*time.Second),
+	)
+	if err != nil {
+		logger.Error.Printf("Failed to create search agent: %v", err)
+		return
+	}
+	defer a.Close()
+
+	res, err := a.GetDocument(options.Space, protocol.DocumentID(options.ID))
+	if err != nil {
+		logger.Error.Printf("Failed to get document: %v", err)
+		return
+	}
+	fmt.Printf("Document fetched in %v seconds with status %q\n", res.Duration, res.Status.String())
+	if res.Status != protocol.SearchStatusIndexHit {
+		return
+	}
+	doc := res.Document
+	fmt.Printf("ID:      %s\n", doc.ID)
+	fmt.Printf("Updated: %v\n", doc.Updated)
+	fmt.Printf("Alive:   %v\n", doc.Alive)
+	fmt.Printf("Boost:   %v\n", doc.Boost)
+	for field, values := range doc.Fields {
+		fmt.Printf("Field:   %s = %s\n", field, strings.Join(values, ", "))
+	}
+	fmt.Printf("Title:   %s\n\n%s\n", doc.Title, doc.Text)
 }
@@ -114,8 +114,9 @@ func handleSearch(vars url.Values) searchResponse {
 	query := vars.Get("query")
 	spaces := vars["space"]
 	limit, _ := strconv.Atoi(vars.Get("limit"))
+	explain := vars.Get("explain") != ""
 	if query == "" {
 		return searchResponse{}
 	}
-	return search(query, spaces, limit)
+	return search(query, spaces, limit, explain)
 }
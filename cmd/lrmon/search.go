@@ -25,6 +25,7 @@ type searchRequest struct {
 	query    string
 	spaces   []string
 	limit    int
+	explain  bool
 	response chan searchResponse
 }
 
@@ -49,7 +50,11 @@ func startSearchClient(URLS []string, seedFile string, rootCAs []string) error {
 
 	go func() {
 		for req := range searchRequests {
-			response, err := agent.Search(req.query, req.spaces, req.limit, 0)
+			var options []client.SearchOption
+			if req.explain {
+				options = append(options, client.WithExplain())
+			}
+			response, err := agent.Search(req.query, req.spaces, req.limit, 0, options...)
 			req.response <- searchResponse{
 				Error:    err,
 				Response: response,
@@ -61,11 +66,12 @@ func startSearchClient(URLS []string, seedFile string, rootCAs []string) error {
 	return nil
 }
 
-func search(query string, spaces []string, limit int) searchResponse {
+func search(query string, spaces []string, limit int, explain bool) searchResponse {
 	req := searchRequest{
 		query:    query,
 		spaces:   spaces,
 		limit:    limit,
+		explain:  explain,
 		response: make(chan searchResponse),
 	}
 	searchRequests <- req
@@ -165,6 +165,14 @@ func main() {
 		}
 	}
 
+	var termStats letarette.TermStatsSharer
+	if cfg.Search.Stats.Global {
+		termStats, err = letarette.StartTermStatsSharer(conn, db, cfg)
+		if err != nil {
+			die("Failed to start term statistics sharing: %v", err)
+		}
+	}
+
 	cloner, err := letarette.StartCloner(conn, db, cfg)
 	if err != nil {
 		die("Failed to start cloner: %v", err)
@@ -181,6 +189,9 @@ func main() {
 	if searcher != nil {
 		searcher.Close()
 	}
+	if termStats != nil {
+		termStats.Close()
+	}
 	if indexer != nil {
 		indexer.Close()
 	}
@@ -15,6 +15,8 @@
  */
 
 #include "auxiliary.h"
+#include "_cgo_export.h"
+#include <math.h>
 #include <string.h>
 #include <stdlib.h>
 
@@ -86,6 +88,14 @@ struct TokenRangeContext {
     int currentToken;
     int textRangeStart;
     int textRangeEnd;
+    // Highlighting state, only used when "out" is set
+    const char* text;
+    const int* matches;
+    int numMatches;
+    int nextMatch;
+    const char* open;
+    const char* close;
+    sqlite3_str* out;
 };
 
 static int tokenRangeCallback(
@@ -98,11 +108,32 @@ static int tokenRangeCallback(
 ) {
     struct TokenRangeContext* ctx = (struct TokenRangeContext*) pCtx;
 
+    // Colocated tokens share position with the previous token
+    if (tflags & FTS5_TOKEN_COLOCATED) {
+        return SQLITE_OK;
+    }
+
     if (ctx->currentToken == ctx->tokenStart) {
         ctx->textRangeStart = iStart;
     }
 
     if (ctx->currentToken >= ctx->tokenStart) {
+        if (ctx->out != 0) {
+            if (ctx->currentToken > ctx->tokenStart) {
+                sqlite3_str_append(ctx->out, ctx->text + ctx->textRangeEnd, iStart - ctx->textRangeEnd);
+            }
+            while (ctx->nextMatch < ctx->numMatches && ctx->matches[ctx->nextMatch] < ctx->currentToken) {
+                ctx->nextMatch++;
+            }
+            int matched = ctx->nextMatch < ctx->numMatches && ctx->matches[ctx->nextMatch] == ctx->currentToken;
+            if (matched) {
+                sqlite3_str_appendall(ctx->out, ctx->open);
+            }
+            sqlite3_str_append(ctx->out, ctx->text + iStart, iEnd - iStart);
+            if (matched) {
+                sqlite3_str_appendall(ctx->out, ctx->close);
+            }
+        }
         ctx->textRangeEnd = iEnd;
     }
 
@@ -115,6 +146,50 @@ static int tokenRangeCallback(
     }
 }
 
+static int compareInts(const void* a, const void* b) {
+    int left = *(const int*) a;
+    int right = *(const int*) b;
+    return (left > right) - (left < right);
+}
+
+// Parses a comma separated list of token offsets, as returned by "matchtokens",
+// into a sorted array. The array is allocated using sqlite3_malloc.
+static int parseTokenList(const char* list, int** tokens, int* numTokens) {
+    *tokens = 0;
+    *numTokens = 0;
+    if (list == 0 || *list == 0) {
+        return SQLITE_OK;
+    }
+
+    int capacity = 1;
+    for (const char* c = list; *c; c++) {
+        if (*c == ',') {
+            capacity++;
+        }
+    }
+    int* parsed = sqlite3_malloc(capacity * sizeof(int));
+    if (parsed == 0) {
+        return SQLITE_NOMEM;
+    }
+
+    int count = 0;
+    const char* current = list;
+    while (*current && count < capacity) {
+        char* end = 0;
+        parsed[count++] = (int) strtol(current, &end, 10);
+        if (end == current) {
+            sqlite3_free(parsed);
+            return SQLITE_ERROR;
+        }
+        current = *end == ',' ? end + 1 : end;
+    }
+    qsort(parsed, count, sizeof(int), compareInts);
+
+    *tokens = parsed;
+    *numTokens = count;
+    return SQLITE_OK;
+}
+
 static void getTokens(
     const Fts5ExtensionApi *pApi,   // API offered by current FTS version
     Fts5Context *pFts,              // First arg to pass to pApi functions
@@ -122,7 +197,7 @@ static void getTokens(
     int nVal,                       // Number of values in apVal[] array
     sqlite3_value **apVal           // Array of trailing arguments
 ) {
-    if (nVal != 3) {
+    if (nVal != 3 && nVal != 6) {
         sqlite3_result_error_code(pCtx, SQLITE_ERROR);
         return;
     }
@@ -131,27 +206,347 @@ static void getTokens(
     int offset = sqlite3_value_int(apVal[1]);
     int count = sqlite3_value_int(apVal[2]);
 
-    if (count < 0 || offset < 0) {
+    if (offset < 0) {
         sqlite3_result_error_code(pCtx, SQLITE_ERROR);
         return;
     }
 
     struct TokenRangeContext ctx = {
-        offset,offset + count,0,0,0
+        // A negative count gets all tokens from the offset
+        offset, count < 0 ? -1 : offset + count, 0, 0, 0
     };
 
+    int* matches = 0;
+    if (nVal == 6 && sqlite3_value_type(apVal[3]) != SQLITE_NULL) {
+        int result = parseTokenList((const char*) sqlite3_value_text(apVal[3]), &matches, &ctx.numMatches);
+        if (result != SQLITE_OK) {
+            sqlite3_result_error_code(pCtx, result);
+            return;
+        }
+        ctx.matches = matches;
+        ctx.open = (const char*) sqlite3_value_text(apVal[4]);
+        ctx.close = (const char*) sqlite3_value_text(apVal[5]);
+        ctx.open = ctx.open ? ctx.open : "";
+        ctx.close = ctx.close ? ctx.close : "";
+        ctx.text = entry;
+        ctx.out = sqlite3_str_new(0);
+    }
+
     int result = pApi->xTokenize(pFts, entry, length, &ctx, tokenRangeCallback);
+    sqlite3_free(matches);
+
     if (result != SQLITE_OK && result != SQLITE_DONE) {
+        if (ctx.out != 0) {
+            sqlite3_free(sqlite3_str_finish(ctx.out));
+        }
         sqlite3_result_error_code(pCtx, result);
         return;
     }
 
+    if (ctx.out != 0) {
+        result = sqlite3_str_errcode(ctx.out);
+        int highlightedLength = sqlite3_str_length(ctx.out);
+        char* highlighted = sqlite3_str_finish(ctx.out);
+        if (result != SQLITE_OK) {
+            sqlite3_free(highlighted);
+            sqlite3_result_error_code(pCtx, result);
+        } else if (highlighted == 0) {
+            sqlite3_result_text(pCtx, "", 0, SQLITE_STATIC);
+        } else {
+            sqlite3_result_text(pCtx, highlighted, highlightedLength, sqlite3_free);
+        }
+        return;
+    }
+
     const char* snippetStart = entry + ctx.textRangeStart;
     int snippetLength = strnlen(snippetStart, ctx.textRangeEnd - ctx.textRangeStart);
     const char* snippet = strndup(snippetStart, snippetLength);
     sqlite3_result_text(pCtx, snippet, snippetLength, free);
 }
 
+struct FragmentContext {
+    const char* text;
+    // Token offset ranges of the fragments, sorted and not touching
+    const int* starts;
+    const int* ends;
+    int numFragments;
+    int fragment;
+    int currentToken;
+    int lastEnd;
+    // Matched tokens to highlight
+    const int* matches;
+    int numMatches;
+    int nextMatch;
+    const char* open;
+    const char* close;
+    sqlite3_str* out;
+};
+
+static int fragmentCallback(
+    void *pCtx,
+    int tflags,
+    const char *pToken,
+    int nToken,
+    int iStart,
+    int iEnd
+) {
+    struct FragmentContext* ctx = (struct FragmentContext*) pCtx;
+
+    if (tflags & FTS5_TOKEN_COLOCATED) {
+        return SQLITE_OK;
+    }
+
+    int token = ctx->currentToken++;
+
+    if (ctx->fragment < ctx->numFragments && token == ctx->ends[ctx->fragment]) {
+        // There is more text after the fragment
+        sqlite3_str_appendall(ctx->out, "…");
+        ctx->fragment++;
+        if (ctx->fragment == ctx->numFragments) {
+            return SQLITE_DONE;
+        }
+    }
+
+    if (ctx->fragment == ctx->numFragments || token < ctx->starts[ctx->fragment]) {
+        return SQLITE_OK;
+    }
+
+    if (token == ctx->starts[ctx->fragment]) {
+        if (ctx->fragment > 0) {
+            sqlite3_str_appendall(ctx->out, " ");
+        }
+        if (token > 0) {
+            sqlite3_str_appendall(ctx->out, "…");
+        }
+    } else {
+        sqlite3_str_append(ctx->out, ctx->text + ctx->lastEnd, iStart - ctx->lastEnd);
+    }
+
+    while (ctx->nextMatch < ctx->numMatches && ctx->matches[ctx->nextMatch] < token) {
+        ctx->nextMatch++;
+    }
+    int matched = ctx->nextMatch < ctx->numMatches && ctx->matches[ctx->nextMatch] == token;
+    if (matched) {
+        sqlite3_str_appendall(ctx->out, ctx->open);
+    }
+    sqlite3_str_append(ctx->out, ctx->text + iStart, iEnd - iStart);
+    if (matched) {
+        sqlite3_str_appendall(ctx->out, ctx->close);
+    }
+    ctx->lastEnd = iEnd;
+
+    return SQLITE_OK;
+}
+
+// Builds a snippet of up to "maxFragments" text fragments of "count" tokens each,
+// starting one token before matched tokens. Fragments are separated by ellipses,
+// and matched tokens are wrapped in the open and close markers.
+static void getFragments(
+    const Fts5ExtensionApi *pApi,   // API offered by current FTS version
+    Fts5Context *pFts,              // First arg to pass to pApi functions
+    sqlite3_context *pCtx,          // Context for returning result/error
+    int nVal,                       // Number of values in apVal[] array
+    sqlite3_value **apVal           // Array of trailing arguments
+) {
+    if (nVal != 6) {
+        sqlite3_result_error_code(pCtx, SQLITE_ERROR);
+        return;
+    }
+    const char* entry = (const char*) sqlite3_value_text(apVal[0]);
+    int length = sqlite3_value_bytes(apVal[0]);
+    int count = sqlite3_value_int(apVal[2]);
+    int maxFragments = sqlite3_value_int(apVal[3]);
+    const char* open = (const char*) sqlite3_value_text(apVal[4]);
+    const char* close = (const char*) sqlite3_value_text(apVal[5]);
+
+    if (count <= 0 || maxFragments <= 0) {
+        sqlite3_result_error_code(pCtx, SQLITE_ERROR);
+        return;
+    }
+
+    int* matches = 0;
+    int numMatches = 0;
+    int result = parseTokenList((const char*) sqlite3_value_text(apVal[1]), &matches, &numMatches);
+    if (result != SQLITE_OK) {
+        sqlite3_result_error_code(pCtx, result);
+        return;
+    }
+
+    int* ranges = sqlite3_malloc(2 * maxFragments * sizeof(int));
+    if (ranges == 0) {
+        sqlite3_free(matches);
+        sqlite3_result_error_code(pCtx, SQLITE_NOMEM);
+        return;
+    }
+    int* starts = ranges;
+    int* ends = ranges + maxFragments;
+
+    // Place fragments at uncovered matches, merging touching fragments
+    int numFragments = 0;
+    int placed = 0;
+    for (int i = 0; i < numMatches && placed < maxFragments; i++) {
+        int match = matches[i];
+        int previousEnd = numFragments > 0 ? ends[numFragments - 1] : 0;
+        if (numFragments > 0 && match < previousEnd) {
+            continue;
+        }
+        int start = match > previousEnd ? match - 1 : previousEnd;
+        if (numFragments > 0 && start == previousEnd) {
+            ends[numFragments - 1] = start + count;
+        } else {
+            starts[numFragments] = start;
+            ends[numFragments] = start + count;
+            numFragments++;
+        }
+        placed++;
+    }
+    if (numFragments == 0) {
+        starts[0] = 0;
+        ends[0] = count;
+        numFragments = 1;
+    }
+
+    struct FragmentContext ctx = {
+        entry, starts, ends, numFragments, 0, 0, 0,
+        matches, numMatches, 0,
+        open ? open : "", close ? close : "",
+        sqlite3_str_new(0)
+    };
+
+    result = pApi->xTokenize(pFts, entry, length, &ctx, fragmentCallback);
+    sqlite3_free(ranges);
+    sqlite3_free(matches);
+
+    if (result == SQLITE_DONE) {
+        result = SQLITE_OK;
+    }
+    if (result == SQLITE_OK) {
+        result = sqlite3_str_errcode(ctx.out);
+    }
+    int snippetLength = sqlite3_str_length(ctx.out);
+    char* snippet = sqlite3_str_finish(ctx.out);
+    if (result != SQLITE_OK) {
+        sqlite3_free(snippet);
+        sqlite3_result_error_code(pCtx, result);
+    } else if (snippet == 0) {
+        sqlite3_result_text(pCtx, "", 0, SQLITE_STATIC);
+    } else {
+        sqlite3_result_text(pCtx, snippet, snippetLength, sqlite3_free);
+    }
+}
+
+// Lists the token offsets of all matched phrase tokens in a column,
+// as a comma separated string.
+static void matchTokens(
+    const Fts5ExtensionApi *pApi,   // API offered by current FTS version
+    Fts5Context *pFts,              // First arg to pass to pApi functions
+    sqlite3_context *pCtx,          // Context for returning result/error
+    int nVal,                       // Number of values in apVal[] array
+    sqlite3_value **apVal           // Array of trailing arguments
+) {
+    if (nVal != 1) {
+        sqlite3_result_error_code(pCtx, SQLITE_ERROR);
+        return;
+    }
+    int column = sqlite3_value_int(apVal[0]);
+
+    int instances = 0;
+    int result = pApi->xInstCount(pFts, &instances);
+    if (result != SQLITE_OK) {
+        sqlite3_result_error_code(pCtx, result);
+        return;
+    }
+
+    sqlite3_str* out = sqlite3_str_new(0);
+    for (int i = 0; i < instances && result == SQLITE_OK; i++) {
+        int phrase = 0;
+        int instanceColumn = 0;
+        int offset = 0;
+        result = pApi->xInst(pFts, i, &phrase, &instanceColumn, &offset);
+        if (result != SQLITE_OK || instanceColumn != column) {
+            continue;
+        }
+        int size = pApi->xPhraseSize(pFts, phrase);
+        for (int token = 0; token < size; token++) {
+            sqlite3_str_appendf(out, "%s%d", sqlite3_str_length(out) ? "," : "", offset + token);
+        }
+    }
+
+    if (result == SQLITE_OK) {
+        result = sqlite3_str_errcode(out);
+    }
+    int length = sqlite3_str_length(out);
+    char* tokens = sqlite3_str_finish(out);
+    if (result != SQLITE_OK) {
+        sqlite3_free(tokens);
+        sqlite3_result_error_code(pCtx, result);
+    } else if (tokens == 0) {
+        sqlite3_result_text(pCtx, "", 0, SQLITE_STATIC);
+    } else {
+        sqlite3_result_text(pCtx, tokens, length, sqlite3_free);
+    }
+}
+
+static int termListCallback(
+    void *pContext,                 // Output string
+    int tflags,                     // Mask of FTS5_TOKEN_* flags
+    const char *pToken,             // Pointer to buffer containing token
+    int nToken,                     // Size of token in bytes
+    int iStart,                     // Byte offset of token within input text
+    int iEnd                        // Byte offset of end of token within input text
+) {
+    if (tflags & FTS5_TOKEN_COLOCATED) {
+        return SQLITE_OK;
+    }
+    sqlite3_str* out = (sqlite3_str*) pContext;
+    if (sqlite3_str_length(out)) {
+        sqlite3_str_appendchar(out, 1, ' ');
+    }
+    sqlite3_str_append(out, pToken, nToken);
+    return sqlite3_str_errcode(out);
+}
+
+// Lists the tokens of all columns of the current row,
+// as a space separated string.
+static void termList(
+    const Fts5ExtensionApi *pApi,   // API offered by current FTS version
+    Fts5Context *pFts,              // First arg to pass to pApi functions
+    sqlite3_context *pCtx,          // Context for returning result/error
+    int nVal,                       // Number of values in apVal[] array
+    sqlite3_value **apVal           // Array of trailing arguments
+) {
+    if (nVal != 0) {
+        sqlite3_result_error_code(pCtx, SQLITE_ERROR);
+        return;
+    }
+
+    int columns = pApi->xColumnCount(pFts);
+    int result = SQLITE_OK;
+    sqlite3_str* out = sqlite3_str_new(0);
+    for (int column = 0; column < columns && result == SQLITE_OK; column++) {
+        const char* text = 0;
+        int length = 0;
+        result = pApi->xColumnText(pFts, column, &text, &length);
+        if (result == SQLITE_OK && text != 0) {
+            result = pApi->xTokenize(pFts, text, length, out, termListCallback);
+        }
+    }
+
+    if (result == SQLITE_OK) {
+        result = sqlite3_str_errcode(out);
+    }
+    int length = sqlite3_str_length(out);
+    char* terms = sqlite3_str_finish(out);
+    if (result != SQLITE_OK) {
+        sqlite3_free(terms);
+        sqlite3_result_error_code(pCtx, result);
+    } else if (terms == 0) {
+        sqlite3_result_text(pCtx, "", 0, SQLITE_STATIC);
+    } else {
+        sqlite3_result_text(pCtx, terms, length, sqlite3_free);
+    }
+}
+
 static void tokenCount(
     const Fts5ExtensionApi *pApi,   // API offered by current FTS version
     Fts5Context *pFts,              // First arg to pass to pApi functions
@@ -173,6 +568,175 @@ static void tokenCount(
     sqlite3_result_int(pCtx, tokens);
 }
 
+struct GlobalRankData {
+    int numPhrases;
+    double avgdl;
+    double* idf;
+    double* freq;
+};
+
+static int countHitsCallback(
+    const Fts5ExtensionApi *pApi,
+    Fts5Context *pFts,
+    void *pUserData
+) {
+    sqlite3_int64* hits = (sqlite3_int64*) pUserData;
+    (*hits)++;
+    return SQLITE_OK;
+}
+
+// Looks up the cluster wide number of documents containing a phrase,
+// as the lowest count of its tokens. Returns -1 when any token is unknown.
+static sqlite3_int64 globalPhraseHits(
+    const Fts5ExtensionApi *pApi,
+    Fts5Context *pFts,
+    int phrase
+) {
+    sqlite3_int64 hits = -1;
+    int size = pApi->xPhraseSize(pFts, phrase);
+    for (int i = 0; i < size; i++) {
+        const char* token = 0;
+        int length = 0;
+        if (pApi->xQueryToken(pFts, phrase, i, &token, &length) != SQLITE_OK) {
+            return -1;
+        }
+        sqlite3_int64 tokenHits = globalTermDocuments((char*) token, length);
+        if (tokenHits < 0) {
+            return -1;
+        }
+        if (hits < 0 || tokenHits < hits) {
+            hits = tokenHits;
+        }
+    }
+    return hits;
+}
+
+// Calculates phrase IDFs and the average document length once per query,
+// from the given cluster wide document and token counts when positive,
+// otherwise from the local index, like the builtin bm25.
+static int getGlobalRankData(
+    const Fts5ExtensionApi *pApi,
+    Fts5Context *pFts,
+    sqlite3_int64 documents,
+    sqlite3_int64 tokens,
+    struct GlobalRankData** data
+) {
+    struct GlobalRankData* p = pApi->xGetAuxdata(pFts, 0);
+    if (p != 0) {
+        *data = p;
+        return SQLITE_OK;
+    }
+
+    int numPhrases = pApi->xPhraseCount(pFts);
+    p = sqlite3_malloc64(sizeof(struct GlobalRankData) + numPhrases * 2 * sizeof(double));
+    if (p == 0) {
+        return SQLITE_NOMEM;
+    }
+    memset(p, 0, sizeof(struct GlobalRankData) + numPhrases * 2 * sizeof(double));
+    p->numPhrases = numPhrases;
+    p->idf = (double*) &p[1];
+    p->freq = &p->idf[numPhrases];
+
+    sqlite3_int64 localRows = 0;
+    sqlite3_int64 localTokens = 0;
+    int result = pApi->xRowCount(pFts, &localRows);
+    if (result == SQLITE_OK) {
+        result = pApi->xColumnTotalSize(pFts, -1, &localTokens);
+    }
+    int global = documents > 0 && tokens > 0 && localRows > 0;
+    double rows = global ? documents : localRows;
+    if (result == SQLITE_OK) {
+        p->avgdl = global ? (double) tokens / documents : (double) localTokens / localRows;
+    }
+
+    for (int i = 0; result == SQLITE_OK && i < numPhrases; i++) {
+        sqlite3_int64 localHits = 0;
+        result = pApi->xQueryPhrase(pFts, i, &localHits, countHitsCallback);
+        if (result != SQLITE_OK) {
+            break;
+        }
+        double hits = localHits;
+        if (global) {
+            sqlite3_int64 globalHits = globalPhraseHits(pApi, pFts, i);
+            if (globalHits < 0) {
+                // Unknown phrases, like prefixes, are assumed to be
+                // as common in the cluster as in the local index
+                hits = (double) localHits * documents / localRows;
+            } else if (globalHits > localHits) {
+                hits = globalHits;
+            }
+        }
+        double idf = log((rows - hits + 0.5) / (hits + 0.5));
+        if (idf <= 0.0) {
+            idf = 1e-6;
+        }
+        p->idf[i] = idf;
+    }
+
+    if (result == SQLITE_OK) {
+        result = pApi->xSetAuxdata(pFts, p, sqlite3_free);
+    } else {
+        sqlite3_free(p);
+    }
+    *data = result == SQLITE_OK ? p : 0;
+    return result;
+}
+
+// Ranks rows like the builtin bm25, but using cluster wide term statistics
+// for phrase IDFs and the average document length.
+static void globalRank(
+    const Fts5ExtensionApi *pApi,   // API offered by current FTS version
+    Fts5Context *pFts,              // First arg to pass to pApi functions
+    sqlite3_context *pCtx,          // Context for returning result/error
+    int nVal,                       // Number of values in apVal[] array
+    sqlite3_value **apVal           // Array of trailing arguments
+) {
+    const double k1 = 1.2;
+    const double b = 0.75;
+
+    if (nVal < 2) {
+        sqlite3_result_error_code(pCtx, SQLITE_ERROR);
+        return;
+    }
+    sqlite3_int64 documents = sqlite3_value_int64(apVal[0]);
+    sqlite3_int64 tokens = sqlite3_value_int64(apVal[1]);
+    int numWeights = nVal - 2;
+    sqlite3_value** weights = apVal + 2;
+
+    struct GlobalRankData* data = 0;
+    int result = getGlobalRankData(pApi, pFts, documents, tokens, &data);
+    int instances = 0;
+    if (result == SQLITE_OK) {
+        memset(data->freq, 0, data->numPhrases * sizeof(double));
+        result = pApi->xInstCount(pFts, &instances);
+    }
+    for (int i = 0; result == SQLITE_OK && i < instances; i++) {
+        int phrase = 0;
+        int column = 0;
+        int offset = 0;
+        result = pApi->xInst(pFts, i, &phrase, &column, &offset);
+        if (result == SQLITE_OK) {
+            data->freq[phrase] += column < numWeights ? sqlite3_value_double(weights[column]) : 1.0;
+        }
+    }
+
+    int rowTokens = 0;
+    if (result == SQLITE_OK) {
+        result = pApi->xColumnSize(pFts, -1, &rowTokens);
+    }
+    if (result != SQLITE_OK) {
+        sqlite3_result_error_code(pCtx, result);
+        return;
+    }
+
+    double score = 0.0;
+    for (int i = 0; i < data->numPhrases; i++) {
+        double freq = data->freq[i];
+        score += data->idf[i] * ((freq * (k1 + 1.0)) / (freq + k1 * (1 - b + b * rowTokens / data->avgdl)));
+    }
+    sqlite3_result_double(pCtx, -1.0 * score);
+}
+
 static fts5_api *fts5APIFromDB(sqlite3 *db){
     fts5_api *pRet = 0;
     sqlite3_stmt *pStmt = 0;
@@ -201,7 +765,7 @@ int initAuxiliaryFunctions(sqlite3* db) {
     }
 
     result = fts->xCreateFunction(
-        // gettokens(fts, text, starttoken, count)
+        // gettokens(fts, text, starttoken, count [, matchtokens, open, close])
         fts, "gettokens", (void*) 0, getTokens, (void*) 0
     );
 
@@ -214,5 +778,41 @@ int initAuxiliaryFunctions(sqlite3* db) {
         fts, "tokens", (void*) 0, tokenCount, (void*) 0
     );
 
+    if (result != SQLITE_OK) {
+        return result;
+    }
+
+    result = fts->xCreateFunction(
+        // fragments(fts, text, matchtokens, count, maxfragments, open, close)
+        fts, "fragments", (void*) 0, getFragments, (void*) 0
+    );
+
+    if (result != SQLITE_OK) {
+        return result;
+    }
+
+    result = fts->xCreateFunction(
+        // matchtokens(fts, column)
+        fts, "matchtokens", (void*) 0, matchTokens, (void*) 0
+    );
+
+    if (result != SQLITE_OK) {
+        return result;
+    }
+
+    result = fts->xCreateFunction(
+        // termlist(fts)
+        fts, "termlist", (void*) 0, termList, (void*) 0
+    );
+
+    if (result != SQLITE_OK) {
+        return result;
+    }
+
+    result = fts->xCreateFunction(
+        // globalbm25(fts, documents, tokens [, weight...])
+        fts, "globalbm25", (void*) 0, globalRank, (void*) 0
+    );
+
     return result;
 }
@@ -12,7 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package auxiliary provides SQL functions "tokens", "gettokens" and "firstmatch"
+// Package auxiliary provides SQL functions "tokens", "gettokens", "firstmatch",
+// "matchtokens", "fragments", "termlist" and "globalbm25"
 package auxiliary
 
 // #cgo CFLAGS: -DSQLITE_CORE
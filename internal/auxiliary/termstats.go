@@ -0,0 +1,69 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auxiliary
+
+import "C"
+import (
+	"sync/atomic"
+)
+
+// TermStatistics are cluster wide term statistics,
+// used by "globalbm25" to rank hits comparably across shards.
+type TermStatistics struct {
+	// Number of documents
+	Documents int64
+	// Total number of tokens in all documents
+	Tokens int64
+	// Number of documents containing each term
+	Terms map[string]int64
+}
+
+var termStatistics atomic.Value
+var termStatisticsVersion atomic.Uint64
+
+// SetTermStatistics sets the term statistics used by all connections.
+// Setting nil makes "globalbm25" rank using local statistics only.
+func SetTermStatistics(stats *TermStatistics) {
+	if stats == nil && GetTermStatistics() == nil {
+		return
+	}
+	termStatistics.Store(stats)
+	termStatisticsVersion.Add(1)
+}
+
+// TermStatisticsVersion returns a counter increased
+// each time the term statistics are changed
+func TermStatisticsVersion() uint64 {
+	return termStatisticsVersion.Load()
+}
+
+// GetTermStatistics returns the current term statistics, or nil
+func GetTermStatistics() *TermStatistics {
+	stats, _ := termStatistics.Load().(*TermStatistics)
+	return stats
+}
+
+//export globalTermDocuments
+func globalTermDocuments(token *C.char, length C.int) C.longlong {
+	stats := GetTermStatistics()
+	if stats == nil {
+		return -1
+	}
+	count, found := stats.Terms[C.GoStringN(token, length)]
+	if !found {
+		return -1
+	}
+	return C.longlong(count)
+}
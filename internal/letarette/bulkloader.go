@@ -16,8 +16,6 @@ package letarette
 
 import (
 	"context"
-	"database/sql"
-	"fmt"
 
 	"github.com/erkkah/letarette/pkg/protocol"
 	"github.com/jmoiron/sqlx"
@@ -38,11 +36,11 @@ func StartBulkLoad(dbo Database, space string) (*BulkLoader, error) {
 		_ = tx.Rollback()
 		return nil, err
 	}
-	statement := tx.StmtxContext(ctx, db.addDocumentStatement)
+	writer := db.documentWriter(ctx, tx)
 	return &BulkLoader{
 		spaceID,
 		tx,
-		statement,
+		writer,
 		sql,
 		0,
 	}, nil
@@ -52,40 +50,17 @@ func StartBulkLoad(dbo Database, space string) (*BulkLoader, error) {
 type BulkLoader struct {
 	spaceID     int
 	tx          *sqlx.Tx
-	statement   *sqlx.Stmt
+	writer      documentWriter
 	db          *sqlx.DB
 	loadedBytes uint32
 }
 
 // Load loads one document into the current loading transaction
 func (bl *BulkLoader) Load(doc protocol.Document) error {
-	txt := ""
-	title := ""
 	if doc.Alive {
-		txt = doc.Text
-		title = doc.Title
+		bl.loadedBytes += uint32(len(doc.Title) + len(doc.Text))
 	}
-
-	bl.loadedBytes += uint32(len(title) + len(txt))
-
-	res, err := bl.statement.Exec(
-		sql.Named("spaceID", bl.spaceID),
-		sql.Named("docID", doc.ID),
-		sql.Named("updated", doc.Updated.UnixNano()),
-		sql.Named("title", title),
-		sql.Named("txt", txt),
-		sql.Named("alive", doc.Alive),
-	)
-
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected != 1 {
-		return fmt.Errorf("unexpected number of rows changed")
-	}
-	return nil
+	return bl.writer.write(context.Background(), bl.spaceID, doc)
 }
 
 // Commit - commits the bulk load transaction and performs
@@ -32,14 +32,15 @@ import (
 // matches a specific shard group. The result is a gzipped, gob-encoded
 // file, ready to be loaded.
 type ShardCloner struct {
-	encoder      *gob.Encoder
-	compressor   *gzip.Writer
-	dest         io.Writer
-	rows         *sqlx.Rows
-	docStatement *sqlx.Stmt
-	targetIndex  int
-	targetSize   int
-	count        int
+	encoder        *gob.Encoder
+	compressor     *gzip.Writer
+	dest           io.Writer
+	rows           *sqlx.Rows
+	docStatement   *sqlx.Stmt
+	fieldStatement *sqlx.Stmt
+	targetIndex    int
+	targetSize     int
+	count          int
 }
 
 type cloneDocument struct {
@@ -49,8 +50,12 @@ type cloneDocument struct {
 	UpdatedNanos int64 `db:"updatedNanos"`
 }
 
+// Clone format versions:
+// 1 - initial version
+// 2 - documents carry rank boosts
+// 3 - documents carry keyword fields
 const (
-	currentCloneVersion = 1
+	currentCloneVersion = 3
 )
 
 // StartShardClone starts the process of cloning all documents in the index for loading
@@ -73,13 +78,22 @@ func StartShardClone(ctx context.Context, db *database, shardGroup string, dest
 	}
 
 	statement, err := db.rdb.PreparexContext(
-		ctx, `select updatedNanos, title, txt as "text", alive from docs where id = ?`,
+		ctx, `select updatedNanos, title, txt as "text", alive, boost from docs where id = ?`,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	fieldStatement, err := db.rdb.PreparexContext(
+		ctx, `select field, value from fields where id = ?`,
+	)
+
+	if err != nil {
+		_ = statement.Close()
+		return nil, err
+	}
+
 	compressor := gzip.NewWriter(dest)
 	encoder := gob.NewEncoder(compressor)
 
@@ -90,13 +104,14 @@ func StartShardClone(ctx context.Context, db *database, shardGroup string, dest
 	}
 
 	return &ShardCloner{
-		dest:         dest,
-		compressor:   compressor,
-		encoder:      encoder,
-		rows:         rows,
-		docStatement: statement,
-		targetIndex:  group - 1,
-		targetSize:   size,
+		dest:           dest,
+		compressor:     compressor,
+		encoder:        encoder,
+		rows:           rows,
+		docStatement:   statement,
+		fieldStatement: fieldStatement,
+		targetIndex:    group - 1,
+		targetSize:     size,
 	}, nil
 }
 
@@ -142,6 +157,11 @@ func (s *ShardCloner) Step(ctx context.Context) (bool, error) {
 
 		doc.Updated = time.Unix(0, doc.UpdatedNanos)
 
+		doc.Fields, err = s.documentFields(ctx, doc.RowID)
+		if err != nil {
+			return true, err
+		}
+
 		if doc.Space != currentSpace {
 			currentSpace = doc.Space
 		} else {
@@ -162,10 +182,27 @@ func (s *ShardCloner) Step(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+func (s *ShardCloner) documentFields(ctx context.Context, rowID int64) (map[string][]string, error) {
+	var fields []struct {
+		Field string
+		Value string
+	}
+	err := s.fieldStatement.SelectContext(ctx, &fields, rowID)
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	result := map[string][]string{}
+	for _, field := range fields {
+		result[field.Field] = append(result[field.Field], field.Value)
+	}
+	return result, nil
+}
+
 // Close stops the cloning process and closes the output.
 func (s *ShardCloner) Close() (int, error) {
 	_ = s.rows.Close()
 	_ = s.docStatement.Close()
+	_ = s.fieldStatement.Close()
 
 	err := s.compressor.Close()
 	if err != nil {
@@ -56,7 +56,21 @@ type Config struct {
 	}
 	Spelling struct {
 		MinFrequency int `split_words:"true" default:"5" desc:"advanced"`
-		MaxLag       int `split_words:"true" default:"100" desc:"advanced"`
+		// Queued document changes are applied to the spelling dictionary
+		// in batches of at most BatchSize terms, one batch per indexer cycle,
+		// when the indexer is idle or when more than MaxLag changes are queued
+		MaxLag    int `split_words:"true" default:"100" desc:"advanced"`
+		BatchSize int `split_words:"true" default:"1000" desc:"advanced"`
+		// Queries with at most FewHits hits get the closest of up to
+		// Corrections alternative spellings, if finding more hits
+		FewHits     int `split_words:"true" default:"2" desc:"advanced"`
+		Corrections int `default:"3" desc:"advanced"`
+		// Fuzzy terms are expanded to at most Terms spelling
+		// dictionary neighbours, within the edit distance budget
+		Fuzzy struct {
+			Terms    int `default:"5" desc:"advanced"`
+			Distance int `default:"200" desc:"advanced"`
+		}
 	}
 	Stemmer struct {
 		Languages        []string `split_words:"true" required:"true" default:"english"`
@@ -71,7 +85,22 @@ type Config struct {
 		CacheTimeout   time.Duration `split_words:"true" default:"10m"`
 		CacheMaxsizeMB uint64        `split_words:"true" default:"250"`
 		Disable        bool          `default:"false" desc:"advanced"`
-		Strategy       int           `default:"1" desc:"internal"`
+		Recency        struct {
+			Weight   float32       `default:"2" desc:"advanced"`
+			HalfLife time.Duration `split_words:"true" default:"720h" desc:"advanced"`
+		}
+		// Per-space title/text rank weights, like "docs:5/1,news:2/1".
+		// Applied to the index at startup, unlisted spaces keep their weights.
+		Weights map[string]RankWeights `desc:"advanced"`
+		// Distributed statistics mode, ranking hits using term
+		// statistics shared by all shards. Each shard shares the
+		// Terms terms found in the most documents. Terms not shared
+		// by all shards are ranked using local statistics.
+		Stats struct {
+			Global   bool          `default:"false"`
+			Interval time.Duration `default:"5m" desc:"advanced"`
+			Terms    int           `default:"100000" desc:"advanced"`
+		}
 	}
 	Shard          string `default:"1/1"`
 	ShardgroupSize uint16 `ignored:"true"`
@@ -114,6 +143,12 @@ func LoadConfig() (cfg Config, err error) {
 		return Config{}, fmt.Errorf("space names must be unique")
 	}
 
+	for space := range cfg.Search.Weights {
+		if _, found := unique[space]; !found {
+			return Config{}, fmt.Errorf("rank weights set for unknown space %q", space)
+		}
+	}
+
 	if !validateIndexDurations(cfg) {
 		return Config{}, fmt.Errorf("invalid index timing settings")
 	}
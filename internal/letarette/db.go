@@ -87,13 +87,20 @@ type Database interface {
 }
 
 type database struct {
-	rdb            *sqlx.DB
-	wdb            *sqlx.DB
-	resultCap      int
-	searchStrategy int
+	rdb       *sqlx.DB
+	wdb       *sqlx.DB
+	resultCap int
+
+	recencyWeight   float32
+	recencyHalfLife time.Duration
+
+	fuzzyTerms    int
+	fuzzyDistance int
 
 	addDocumentStatement    *sqlx.Stmt
 	updateInterestStatement *sqlx.Stmt
+	deleteFieldsStatement   *sqlx.Stmt
+	addFieldStatement       *sqlx.Stmt
 }
 
 // OpenDatabase connects to a new or existing database and
@@ -134,13 +141,35 @@ func OpenDatabase(cfg Config) (Database, error) {
 		return nil, fmt.Errorf("failed to prepare interest update statement: %w", err)
 	}
 
+	deleteFieldsStatement, err := wdb.Preparex(deleteFieldsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare field delete statement: %w", err)
+	}
+
+	addFieldStatement, err := wdb.Preparex(addFieldSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare field update statement: %w", err)
+	}
+
+	if !cfg.DB.ToolConnection {
+		err = applyRankWeights(wdb, cfg.Search.Weights)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	newDB := &database{
 		rdb:                     rdb,
 		wdb:                     wdb,
 		resultCap:               cfg.Search.Cap,
-		searchStrategy:          cfg.Search.Strategy,
+		recencyWeight:           cfg.Search.Recency.Weight,
+		recencyHalfLife:         cfg.Search.Recency.HalfLife,
+		fuzzyTerms:              cfg.Spelling.Fuzzy.Terms,
+		fuzzyDistance:           cfg.Spelling.Fuzzy.Distance,
 		addDocumentStatement:    addDocumentStatement,
 		updateInterestStatement: updateInterestStatement,
+		deleteFieldsStatement:   deleteFieldsStatement,
+		addFieldStatement:       addFieldStatement,
 	}
 	return newDB, nil
 }
@@ -190,6 +219,14 @@ func (db *database) Close() error {
 		errs = append(errs, err)
 	}
 
+	if err := db.deleteFieldsStatement.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := db.addFieldStatement.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
 	logger.Debug.Printf("Closing database")
 	if err := db.rdb.Close(); err != nil {
 		errs = append(errs, err)
@@ -353,12 +390,6 @@ func initDB(db *sqlx.DB, spaces []string) error {
 		if err != nil {
 			return fmt.Errorf("failed to set default page size: %w", err)
 		}
-
-		// Give titles three times the weight of the text body
-		_, err = db.Exec(`insert into fts (fts, rank) values("rank", "bm25(5.0, 1.0)")`)
-		if err != nil {
-			return fmt.Errorf("failed to set ranking weights: %w", err)
-		}
 	}
 
 	return nil
@@ -0,0 +1,92 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+// searchFacets counts the hits of a search per space and per field value.
+// The search parameters are the same as for the search strategies, to count
+// over the same set of capped matches.
+func (db *database) searchFacets(
+	ctx context.Context, params map[string]interface{}, request protocol.SearchRequest,
+) (
+	protocol.SearchFacets, error,
+) {
+	var facets protocol.SearchFacets
+
+	query, err := searchSQL("facets.sql")
+	if err != nil {
+		return facets, err
+	}
+
+	fieldFacets := request.FieldFacets
+	if fieldFacets == nil {
+		fieldFacets = []string{}
+	}
+	fieldsJSON, err := json.Marshal(fieldFacets)
+	if err != nil {
+		return facets, err
+	}
+
+	facetParams := map[string]interface{}{
+		"spaceFacets": request.SpaceFacets,
+		"fieldFacets": string(fieldsJSON),
+	}
+	for key, value := range params {
+		facetParams[key] = value
+	}
+	// Facets count all matches, also on cursor pages
+	facetParams["after"] = false
+
+	namedQuery, args, err := bindSearchQuery(query, facetParams)
+	if err != nil {
+		return facets, err
+	}
+
+	var counts []struct {
+		IsSpace bool `db:"isSpace"`
+		Field   string
+		Value   string
+		Count   int
+	}
+	err = db.rdb.SelectContext(ctx, &counts, namedQuery, args...)
+	if err != nil {
+		return facets, fmt.Errorf("failed to count facets: %w", err)
+	}
+
+	if request.SpaceFacets {
+		facets.Spaces = map[string]int{}
+	}
+	if len(request.FieldFacets) > 0 {
+		facets.Fields = map[string]map[string]int{}
+		for _, field := range request.FieldFacets {
+			facets.Fields[field] = map[string]int{}
+		}
+	}
+	for _, count := range counts {
+		if count.IsSpace {
+			facets.Spaces[count.Value] = count.Count
+		} else {
+			facets.Fields[count.Field][count.Value] = count.Count
+		}
+	}
+	return facets, nil
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/erkkah/letarette/internal/snowball"
 	"github.com/erkkah/letarette/pkg/protocol"
+	"github.com/jmoiron/sqlx"
 )
 
 func (db *database) getLastUpdateTime(ctx context.Context, space string) (t time.Time, err error) {
@@ -40,13 +41,21 @@ func (db *database) getDocumentCount(ctx context.Context) (uint64, error) {
 }
 
 var addCompressedDocumentSQL = `
-replace into docs (spaceID, docID, updatedNanos, title, txt, alive)
-values (:spaceID, :docID, :updated, :title, compress(:txt), :alive);
+replace into docs (spaceID, docID, updatedNanos, title, txt, alive, boost)
+values (:spaceID, :docID, :updated, :title, compress(:txt), :alive, :boost);
 `
 
 var addUncompressedDocumentSQL = `
-replace into docs (spaceID, docID, updatedNanos, title, txt, alive)
-values (:spaceID, :docID, :updated, :title, :txt, :alive);
+replace into docs (spaceID, docID, updatedNanos, title, txt, alive, boost)
+values (:spaceID, :docID, :updated, :title, :txt, :alive, :boost);
+`
+
+var deleteFieldsSQL = `
+delete from fields where id = (select id from docs where spaceID = :spaceID and docID = :docID);
+`
+
+var addFieldSQL = `
+insert or ignore into fields (id, field, value) values (:id, :field, :value);
 `
 
 var updateInterestSQL = `
@@ -70,36 +79,15 @@ func (db *database) addDocumentUpdates(ctx context.Context, space string, docs [
 		}
 	}()
 
-	docsStatement := tx.StmtxContext(ctx, db.addDocumentStatement)
+	writer := db.documentWriter(ctx, tx)
 	interestStatement := tx.StmtxContext(ctx, db.updateInterestStatement)
 
 	for _, doc := range docs {
-		txt := ""
-		title := ""
-		if doc.Alive {
-			txt = doc.Text
-			title = doc.Title
-		}
-
-		res, err := docsStatement.ExecContext(
-			ctx,
-			sql.Named("spaceID", spaceID),
-			sql.Named("docID", doc.ID),
-			sql.Named("updated", doc.Updated.UnixNano()),
-			sql.Named("title", title),
-			sql.Named("txt", txt),
-			sql.Named("alive", doc.Alive),
-		)
-
+		err = writer.write(ctx, spaceID, doc)
 		if err != nil {
 			return fmt.Errorf("failed to update doc: %w", err)
 		}
 
-		updatedRows, _ := res.RowsAffected()
-		if updatedRows != 1 {
-			return fmt.Errorf("failed to update index, no rows affected")
-		}
-
 		_, err = interestStatement.ExecContext(
 			ctx,
 			sql.Named("state", served),
@@ -119,6 +107,83 @@ func (db *database) addDocumentUpdates(ctx context.Context, space string, docs [
 	return err
 }
 
+// documentWriter stores documents and their fields
+// using transaction bound statements
+type documentWriter struct {
+	addDocument  *sqlx.Stmt
+	deleteFields *sqlx.Stmt
+	addField     *sqlx.Stmt
+}
+
+func (db *database) documentWriter(ctx context.Context, tx *sqlx.Tx) documentWriter {
+	return documentWriter{
+		addDocument:  tx.StmtxContext(ctx, db.addDocumentStatement),
+		deleteFields: tx.StmtxContext(ctx, db.deleteFieldsStatement),
+		addField:     tx.StmtxContext(ctx, db.addFieldStatement),
+	}
+}
+
+func (w documentWriter) write(ctx context.Context, spaceID int, doc protocol.Document) error {
+	txt := ""
+	title := ""
+	if doc.Alive {
+		txt = doc.Text
+		title = doc.Title
+	}
+
+	// Replacing the document gives it a new row id
+	_, err := w.deleteFields.ExecContext(
+		ctx,
+		sql.Named("spaceID", spaceID),
+		sql.Named("docID", doc.ID),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete fields: %w", err)
+	}
+
+	res, err := w.addDocument.ExecContext(
+		ctx,
+		sql.Named("spaceID", spaceID),
+		sql.Named("docID", doc.ID),
+		sql.Named("updated", doc.Updated.UnixNano()),
+		sql.Named("title", title),
+		sql.Named("txt", txt),
+		sql.Named("alive", doc.Alive),
+		sql.Named("boost", doc.Boost),
+	)
+	if err != nil {
+		return err
+	}
+
+	updatedRows, _ := res.RowsAffected()
+	if updatedRows != 1 {
+		return fmt.Errorf("failed to update index, no rows affected")
+	}
+
+	if !doc.Alive {
+		return nil
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for field, values := range doc.Fields {
+		for _, value := range values {
+			_, err = w.addField.ExecContext(
+				ctx,
+				sql.Named("id", id),
+				sql.Named("field", field),
+				sql.Named("value", value),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to add field: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
 func (db *database) commitInterestList(ctx context.Context, space string) error {
 	tx, err := db.wdb.BeginTxx(ctx, nil)
 	if err != nil {
@@ -268,6 +333,44 @@ func (db *database) hasDocument(ctx context.Context, space string, doc Interest)
 	return exists, err
 }
 
+// getDocument reads a stored document, with uncompressed text.
+// Returns false if the document is not in the index.
+func (db *database) getDocument(ctx context.Context, space string, docID protocol.DocumentID) (protocol.Document, bool, error) {
+	var doc struct {
+		RowID        int64 `db:"rowid"`
+		UpdatedNanos int64 `db:"updatedNanos"`
+		protocol.Document
+	}
+	err := db.rdb.GetContext(ctx, &doc, `
+	select docs.id as rowid, docID as id, updatedNanos, title, uncompress(txt) as "text", alive, boost
+	from docs join spaces using(spaceID)
+	where space = ? and docID = ?
+	`, space, docID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return protocol.Document{}, false, nil
+	}
+	if err != nil {
+		return protocol.Document{}, false, err
+	}
+	doc.Updated = time.Unix(0, doc.UpdatedNanos)
+
+	var fields []struct {
+		Field string
+		Value string
+	}
+	err = db.rdb.SelectContext(ctx, &fields, `select field, value from fields where id = ?`, doc.RowID)
+	if err != nil {
+		return protocol.Document{}, false, err
+	}
+	for _, field := range fields {
+		if doc.Fields == nil {
+			doc.Fields = map[string][]string{}
+		}
+		doc.Fields[field.Field] = append(doc.Fields[field.Field], field.Value)
+	}
+	return doc.Document, true, nil
+}
+
 func (db *database) setInterestList(ctx context.Context, indexUpdate protocol.IndexUpdate) error {
 
 	tx, err := db.wdb.BeginTxx(ctx, nil)
@@ -16,92 +16,496 @@ package letarette
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
+	"github.com/erkkah/letarette/internal/auxiliary"
 	"github.com/erkkah/letarette/pkg/protocol"
 )
 
-func phrasesToMatchString(phrases []Phrase) string {
-	var includes []string
-	var excludes []string
+// fieldColumns maps query fields to fts table columns
+var fieldColumns = map[string]string{
+	FieldTitle: "title",
+	FieldText:  "txt",
+}
 
-	for _, v := range phrases {
-		phraseExpr := v.Text
-		if !strings.HasPrefix(v.Text, `"`) {
-			phraseExpr = fmt.Sprintf("%q", v.Text)
+func phraseToMatchString(phrase Phrase) string {
+	phraseExpr := phrase.Text
+	if !strings.HasPrefix(phrase.Text, `"`) {
+		phraseExpr = fmt.Sprintf("%q", phrase.Text)
+	}
+	if phrase.Wildcard {
+		phraseExpr += "*"
+	}
+	if column, ok := fieldColumns[phrase.Field]; ok {
+		phraseExpr = column + " : " + phraseExpr
+	}
+	return phraseExpr
+}
+
+// matchOptions controls how the phrases of a conjunction are combined
+type matchOptions struct {
+	mode     protocol.MatchMode
+	distance int
+}
+
+var defaultMatchOptions = matchOptions{
+	mode:     protocol.MatchProximity,
+	distance: protocol.DefaultMatchDistance,
+}
+
+var errInvalidQuery = errors.New("invalid query")
+
+func matchOptionsFromRequest(query protocol.SearchRequest) (matchOptions, error) {
+	options := matchOptions{mode: query.MatchMode}
+	switch query.MatchMode {
+	case protocol.MatchProximity:
+		options.distance = int(query.MatchDistance)
+		if options.distance == 0 {
+			options.distance = protocol.DefaultMatchDistance
 		}
-		if v.Wildcard {
-			phraseExpr += "*"
+	case protocol.MatchAll, protocol.MatchAny:
+	default:
+		return options, fmt.Errorf("%w: unknown match mode %d", errInvalidQuery, query.MatchMode)
+	}
+	return options, nil
+}
+
+// updatedRange returns the half-open interval of document update
+// times, in nanoseconds, matched by a request
+func updatedRange(request protocol.SearchRequest, now time.Time) (int64, int64, error) {
+	from := int64(math.MinInt64)
+	to := int64(math.MaxInt64)
+
+	if !request.UpdatedFrom.IsZero() {
+		from = request.UpdatedFrom.UnixNano()
+	}
+	if request.UpdatedWithin < 0 {
+		return from, to, fmt.Errorf("%w: negative update duration", errInvalidQuery)
+	}
+	if request.UpdatedWithin > 0 {
+		within := now.Add(-request.UpdatedWithin).UnixNano()
+		if within > from {
+			from = within
 		}
-		if v.Exclude {
-			excludes = append(excludes, phraseExpr)
+	}
+	if !request.UpdatedTo.IsZero() {
+		to = request.UpdatedTo.UnixNano()
+	}
+	if from > to {
+		return from, to, fmt.Errorf("%w: empty update time range", errInvalidQuery)
+	}
+	return from, to, nil
+}
+
+// Text snippet size limits
+const maxSnippetTokens = 250
+const maxSnippetFragments = 10
+
+// snippetOptions controls snippet generation and the search strategy
+type snippetOptions struct {
+	strategy  int
+	tokens    int
+	fragments int
+}
+
+// snippetStrategies maps snippet modes to search query strategies
+var snippetStrategies = map[protocol.SnippetMode]int{
+	protocol.SnippetText:  1,
+	protocol.SnippetTitle: 3,
+	protocol.SnippetNone:  3,
+}
+
+func snippetOptionsFromRequest(request protocol.SearchRequest) (snippetOptions, error) {
+	strategy, found := snippetStrategies[request.Snippet]
+	if !found {
+		return snippetOptions{}, fmt.Errorf("%w: unknown snippet mode %d", errInvalidQuery, request.Snippet)
+	}
+	tokens := int(request.SnippetTokens)
+	if tokens == 0 {
+		tokens = protocol.DefaultSnippetTokens
+	}
+	fragments := max(int(request.SnippetFragments), 1)
+	return snippetOptions{
+		strategy:  strategy,
+		tokens:    min(tokens, maxSnippetTokens),
+		fragments: min(fragments, maxSnippetFragments),
+	}, nil
+}
+
+// rankOptions controls hit ordering and the recency boost
+type rankOptions struct {
+	byDate bool
+	weight float64
+	// in nanoseconds
+	halfLife float64
+}
+
+func (db *database) rankOptionsFromRequest(request protocol.SearchRequest) (rankOptions, error) {
+	options := rankOptions{halfLife: 1}
+	switch request.Sort {
+	case protocol.SortByRank:
+	case protocol.SortByDate:
+		options.byDate = true
+	case protocol.SortByRecency:
+		weight := request.RecencyWeight
+		if weight == 0 {
+			weight = db.recencyWeight
+		}
+		halfLife := request.RecencyHalfLife
+		if halfLife == 0 {
+			halfLife = db.recencyHalfLife
+		}
+		if weight < 0 || halfLife <= 0 {
+			return options, fmt.Errorf("%w: invalid recency boost settings", errInvalidQuery)
+		}
+		options.weight = float64(weight)
+		options.halfLife = float64(halfLife)
+	default:
+		return options, fmt.Errorf("%w: unknown sort order %d", errInvalidQuery, request.Sort)
+	}
+	return options, nil
+}
+
+func (options matchOptions) operator() string {
+	if options.mode == protocol.MatchAny {
+		return " OR "
+	}
+	return " AND "
+}
+
+// includedPhrases compiles included phrases into a list of match strings,
+// to be combined by the match mode operator.
+// In proximity mode, all phrases are put in one "near" group.
+// Since FTS5 column filters cannot be applied to single phrases within
+// a "near" group, field scoped phrases are kept outside of it.
+func includedPhrases(phrases []Phrase, options matchOptions) []string {
+	var includes []string
+	var scoped []string
+
+	for _, v := range phrases {
+		phraseExpr := phraseToMatchString(v)
+		if v.Field != "" {
+			scoped = append(scoped, phraseExpr)
 		} else {
 			includes = append(includes, phraseExpr)
 		}
 	}
 
-	const nearRange = 15
-	matchString := ""
-	if len(includes) > 0 {
-		matchString += fmt.Sprintf("NEAR(%s, %d)", strings.Join(includes, " "), nearRange)
+	if len(includes) > 0 && options.mode == protocol.MatchProximity {
+		includes = []string{fmt.Sprintf("NEAR(%s, %d)", strings.Join(includes, " "), options.distance)}
 	}
+	return append(includes, scoped...)
+}
+
+func joinMatchStrings(includes []string, excludes []string, options matchOptions) string {
+	matchString := strings.Join(includes, options.operator())
 	if len(excludes) > 0 {
+		if len(includes) > 1 && options.mode == protocol.MatchAny {
+			matchString = "(" + matchString + ")"
+		}
 		matchString += fmt.Sprintf(" NOT (%s)", strings.Join(excludes, " OR "))
 	}
-
 	return matchString
 }
 
-func (db *database) search(
-	ctx context.Context, phrases []Phrase, spaces []string, pageLimit uint16, pageOffset uint16,
+// nearToMatchString compiles a NEAR/n group. FTS5 only supports plain
+// phrases in a "near" group, with an optional column filter for the
+// whole group. Other groups are not expressible.
+func nearToMatchString(expr Expression) (string, bool) {
+	var phrases []string
+	field := ""
+	for i, operand := range expr.Operands {
+		if operand.isGroup() || operand.Phrase.Exclude {
+			return "", false
+		}
+		if i == 0 {
+			field = operand.Phrase.Field
+		} else if operand.Phrase.Field != field {
+			return "", false
+		}
+		unscoped := operand.Phrase
+		unscoped.Field = ""
+		phrases = append(phrases, phraseToMatchString(unscoped))
+	}
+	matchString := fmt.Sprintf("NEAR(%s, %d)", strings.Join(phrases, " "), expr.Distance)
+	if column, ok := fieldColumns[field]; ok {
+		matchString = column + " : " + matchString
+	}
+	return matchString, true
+}
+
+// expressionToMatchString compiles an expression tree to an FTS5 match string.
+// Phrases in a conjunction are matched using includedPhrases, sub groups
+// are combined with the phrase match, and excluded sub groups are added to the NOT list.
+// NEAR/n groups that cannot be expressed in FTS5 are matched as conjunctions.
+// Terms that cannot be expressed, like excluded alternatives, are dropped.
+// Returns an empty string for expressions that cannot be matched.
+func expressionToMatchString(expr Expression, options matchOptions) string {
+	if !expr.isGroup() {
+		if expr.Phrase.Exclude {
+			return ""
+		}
+		return phraseToMatchString(expr.Phrase)
+	}
+
+	if expr.Operator == OpNear {
+		if matchString, ok := nearToMatchString(expr); ok {
+			return matchString
+		}
+		expr.Operator = OpAnd
+		options = matchOptions{mode: protocol.MatchAll}
+	}
+
+	if expr.Operator == OpOr {
+		var alternatives []string
+		for _, operand := range expr.Operands {
+			if operand.excluded() {
+				continue
+			}
+			alternative := expressionToMatchString(operand, options)
+			if alternative == "" {
+				continue
+			}
+			if operand.isGroup() {
+				alternative = "(" + alternative + ")"
+			}
+			alternatives = append(alternatives, alternative)
+		}
+		return strings.Join(alternatives, " OR ")
+	}
+
+	var phrases []Phrase
+	var includes []string
+	var excludes []string
+
+	for _, operand := range expr.Operands {
+		if !operand.isGroup() {
+			if operand.Phrase.Exclude {
+				excludes = append(excludes, phraseToMatchString(operand.Phrase))
+			} else {
+				phrases = append(phrases, operand.Phrase)
+			}
+			continue
+		}
+		included := operand
+		included.Exclude = false
+		groupExpr := expressionToMatchString(included, options)
+		if groupExpr == "" {
+			continue
+		}
+		if operand.Exclude {
+			excludes = append(excludes, "("+groupExpr+")")
+		} else {
+			includes = append(includes, "("+groupExpr+")")
+		}
+	}
+
+	includes = append(includedPhrases(phrases, options), includes...)
+	if len(includes) == 0 {
+		return ""
+	}
+
+	return joinMatchStrings(includes, excludes, options)
+}
+
+// preparedSearch holds the named parameters of the search queries,
+// shared by the search strategies, facet counts and hit counts
+type preparedSearch struct {
+	params   map[string]interface{}
+	after    *protocol.SearchCursor
+	walk     bool
+	offset   int
+	now      time.Time
+	strategy int
+}
+
+func (db *database) prepareSearch(
+	ctx context.Context, expression Expression, request protocol.SearchRequest,
 ) (
-	protocol.SearchResult, error,
+	preparedSearch, error,
 ) {
+	options, err := matchOptionsFromRequest(request)
+	if err != nil {
+		return preparedSearch{}, err
+	}
+	matchString := expressionToMatchString(expression, options)
+	if matchString == "" {
+		return preparedSearch{}, fmt.Errorf("empty search expression")
+	}
 
-	if len(phrases) == 0 {
-		return protocol.SearchResult{}, fmt.Errorf("empty search phrase list")
+	// Fuzzy searches match the expanded expression, and the
+	// original expression is used to flag the fuzzy only matches
+	err = checkFuzzyNear(expression)
+	if err != nil {
+		return preparedSearch{}, err
+	}
+	exactMatch := matchString
+	neighbours, err := db.fuzzyNeighbours(ctx, expression.Phrases(), request.Fuzzy)
+	if err != nil {
+		return preparedSearch{}, err
+	}
+	fuzzy := len(neighbours) > 0
+	if fuzzy {
+		matchString = expressionToMatchString(expandFuzzy(expression, neighbours, request.Fuzzy), options)
 	}
 
-	matchString := phrasesToMatchString(phrases)
+	walk := request.After != ""
+	var after *protocol.SearchCursor
+	if walk && request.After != protocol.CursorStart {
+		cursor, err := protocol.ParseSearchCursor(request.After)
+		if err != nil {
+			return preparedSearch{}, fmt.Errorf("%w: %v", errInvalidQuery, err)
+		}
+		if cursor.Sort != request.Sort {
+			return preparedSearch{}, fmt.Errorf("%w: cursor sort order mismatch", errInvalidQuery)
+		}
+		after = &cursor
+	}
 
-	query, err := loadSearchQuery(db.searchStrategy)
+	now := request.Now
+	if now.IsZero() && after != nil {
+		now = after.Now
+	}
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	updatedFrom, updatedTo, err := updatedRange(request, now)
 	if err != nil {
-		return protocol.SearchResult{}, fmt.Errorf("search strategy %d not found", db.searchStrategy)
+		return preparedSearch{}, err
 	}
 
-	type hit struct {
-		protocol.SearchHit
-		Total int
+	ranking, err := db.rankOptionsFromRequest(request)
+	if err != nil {
+		return preparedSearch{}, err
 	}
-	var hits []hit
 
-	var result protocol.SearchResult
+	filters, err := parseFilters(request.Filters)
+	if err != nil {
+		return preparedSearch{}, err
+	}
 
-	spaceArgs := make([]interface{}, len(spaces))
-	for i, v := range spaces {
-		spaceArgs[i] = v
+	snippet, err := snippetOptionsFromRequest(request)
+	if err != nil {
+		return preparedSearch{}, err
 	}
-	spacedQuery, spacedArgs, err := sqlx.In(query, spaceArgs...)
+
+	exclude := request.Exclude
+	if exclude == nil {
+		exclude = []protocol.DocumentLocator{}
+	}
+	excludeJSON, err := json.Marshal(exclude)
 	if err != nil {
-		return result, fmt.Errorf("failed to expand 'in' values: %w", err)
+		return preparedSearch{}, err
+	}
+
+	resultCap := db.resultCap + 1
+	offset := int(request.PageOffset) * int(request.PageLimit)
+	var afterKey interface{} = 0
+	var afterRank float64
+	var afterSpace string
+	var afterID protocol.DocumentID
+
+	if walk {
+		// Cursor requests walk all matches
+		resultCap = -1
+		offset = 0
+	}
+	if after != nil {
+		afterKey = after.Fuzzy
+		if ranking.byDate {
+			afterKey = -after.Updated.UnixNano()
+		}
+		afterRank = after.Rank
+		afterSpace = after.Space
+		afterID = after.ID
+	}
+
+	// Zero counts make globalbm25 use local statistics
+	var statsDocuments, statsTokens int64
+	if stats := auxiliary.GetTermStatistics(); stats != nil {
+		statsDocuments = stats.Documents
+		statsTokens = stats.Tokens
+	}
+
+	params := map[string]interface{}{
+		"match":            matchString,
+		"fuzzy":            fuzzy,
+		"exactMatch":       exactMatch,
+		"statsDocuments":   statsDocuments,
+		"statsTokens":      statsTokens,
+		"updatedFrom":      updatedFrom,
+		"updatedTo":        updatedTo,
+		"filters":          filters,
+		"exclude":          string(excludeJSON),
+		"recencyWeight":    ranking.weight,
+		"recencyHalfLife":  ranking.halfLife,
+		"now":              now.UnixNano(),
+		"after":            after != nil,
+		"count":            after == nil,
+		"afterKey":         afterKey,
+		"afterRank":        afterRank,
+		"afterSpace":       afterSpace,
+		"afterID":          afterID,
+		"cap":              resultCap,
+		"spaces":           request.Spaces,
+		"sortByDate":       ranking.byDate,
+		"limit":            request.PageLimit,
+		"offset":           offset,
+		"snippetTitle":     request.Snippet == protocol.SnippetTitle,
+		"snippetTokens":    snippet.tokens,
+		"snippetFragments": snippet.fragments,
+		"highlight":        request.HighlightOpen != "" || request.HighlightClose != "",
+		"highlightOpen":    request.HighlightOpen,
+		"highlightClose":   request.HighlightClose,
 	}
 
-	namedQuery, namedArgs, err := sqlx.Named(spacedQuery, map[string]interface{}{
-		"match":  matchString,
-		"cap":    db.resultCap + 1,
-		"limit":  pageLimit,
-		"offset": pageOffset * pageLimit,
-	})
+	return preparedSearch{
+		params:   params,
+		after:    after,
+		walk:     walk,
+		offset:   offset,
+		now:      now,
+		strategy: snippet.strategy,
+	}, nil
+}
+
+func (db *database) search(
+	ctx context.Context, expression Expression, request protocol.SearchRequest,
+) (
+	protocol.SearchResult, error,
+) {
+	var result protocol.SearchResult
+
+	prepared, err := db.prepareSearch(ctx, expression, request)
+	if err != nil {
+		return result, err
+	}
+	after := prepared.after
+
+	query, err := loadSearchQuery(prepared.strategy)
 	if err != nil {
-		return result, fmt.Errorf("failed to expand named binds: %w", err)
+		return result, fmt.Errorf("search strategy %d not found", prepared.strategy)
 	}
 
-	args := append(namedArgs[:0:0], namedArgs[:2]...)
-	args = append(args, spacedArgs...)
-	args = append(args, namedArgs[2:]...)
+	type hit struct {
+		protocol.SearchHit
+		Total        int
+		UpdatedNanos int64 `db:"updatedNanos"`
+	}
+	var hits []hit
+
+	namedQuery, args, err := bindSearchQuery(query, prepared.params)
+	if err != nil {
+		return result, err
+	}
 
 	//logger.Debug.Printf("Search query: [%s], args: %v", namedQuery, args)
 	err = db.rdb.SelectContext(ctx, &hits, namedQuery, args...)
@@ -109,17 +513,77 @@ func (db *database) search(
 		return result, err
 	}
 
+	if request.SpaceFacets || len(request.FieldFacets) > 0 {
+		result.Facets, err = db.searchFacets(ctx, prepared.params, request)
+		if err != nil {
+			return result, err
+		}
+	}
+
 	if len(hits) > 0 {
 		result.TotalHits = hits[0].Total
 	}
-	if result.TotalHits > db.resultCap {
+	if after != nil {
+		// Hits are only counted at the start of a walk
+		result.TotalHits = after.Remaining
+	}
+	if !prepared.walk && result.TotalHits > db.resultCap {
 		result.TotalHits = db.resultCap
 		result.Capped = true
 	}
 	result.Hits = make([]protocol.SearchHit, len(hits))
 	for i, hit := range hits {
 		result.Hits[i] = hit.SearchHit
+		result.Hits[i].Updated = time.Unix(0, hit.UpdatedNanos)
+	}
+	if len(hits) > 0 {
+		remaining := result.TotalHits - prepared.offset - len(hits)
+		result.Cursor = protocol.NewSearchCursor(result.Hits[len(hits)-1], request.Sort, prepared.now, remaining)
 	}
 
 	return result, err
 }
+
+// countHits counts the matches of a search, capped like the search total,
+// without ranking, paging, snippets or facets
+func (db *database) countHits(
+	ctx context.Context, expression Expression, request protocol.SearchRequest,
+) (
+	int, error,
+) {
+	request.After = ""
+	prepared, err := db.prepareSearch(ctx, expression, request)
+	if err != nil {
+		return 0, err
+	}
+
+	query, err := searchSQL("count.sql")
+	if err != nil {
+		return 0, err
+	}
+	namedQuery, args, err := bindSearchQuery(query, prepared.params)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = db.rdb.GetContext(ctx, &count, namedQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+	return min(count, db.resultCap), nil
+}
+
+// bindSearchQuery expands named parameters and "in" lists of a search query
+func bindSearchQuery(query string, params map[string]interface{}) (string, []interface{}, error) {
+	namedQuery, namedArgs, err := sqlx.Named(query, params)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to expand named binds: %w", err)
+	}
+
+	namedQuery, args, err := sqlx.In(namedQuery, namedArgs...)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to expand 'in' values: %w", err)
+	}
+	return namedQuery, args, nil
+}
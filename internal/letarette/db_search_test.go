@@ -0,0 +1,1215 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erkkah/letarette/internal/auxiliary"
+	"github.com/erkkah/letarette/pkg/protocol"
+	xt "github.com/erkkah/letarette/pkg/xt"
+)
+
+var allSnippetModes = []protocol.SnippetMode{
+	protocol.SnippetText, protocol.SnippetTitle, protocol.SnippetNone,
+}
+
+func matchStringFromQuery(query string) string {
+	return matchStringFromQueryWithOptions(query, defaultMatchOptions)
+}
+
+func matchStringFromQueryWithOptions(query string, options matchOptions) string {
+	return expressionToMatchString(ReduceExpression(ParseExpression(query)), options)
+}
+
+func TestMatchString_Flat(t *testing.T) {
+	xt := xt.X(t)
+
+	xt.Equal(matchStringFromQuery(`cat dog*`), `NEAR("cat" "dog"*, 15)`)
+	xt.Equal(matchStringFromQuery(`cat -dog -"horse head"`), `NEAR("cat", 15) NOT ("dog" OR "horse head")`)
+}
+
+func TestMatchString_Groups(t *testing.T) {
+	xt := xt.X(t)
+
+	xt.Equal(
+		matchStringFromQuery(`(invoice OR receipt) -draft`),
+		`("invoice" OR "receipt") NOT ("draft")`,
+	)
+	xt.Equal(
+		matchStringFromQuery(`tax (invoice OR receipt*) -(draft OR copy)`),
+		`NEAR("tax", 15) AND ("invoice" OR "receipt"*) NOT (("draft" OR "copy"))`,
+	)
+	xt.Equal(
+		matchStringFromQuery(`cat dog OR horse -pony`),
+		`(NEAR("cat" "dog", 15)) OR (NEAR("horse", 15) NOT ("pony"))`,
+	)
+}
+
+func TestMatchString_Unmatchable(t *testing.T) {
+	xt := xt.X(t)
+
+	xt.Equal(matchStringFromQuery(`-dog`), ``)
+	xt.Equal(matchStringFromQuery(`-dog -(cat OR horse)`), ``)
+	xt.Equal(matchStringFromQuery(`cat OR -dog`), `"cat"`)
+}
+
+func TestMatchString_Fields(t *testing.T) {
+	xt := xt.X(t)
+
+	xt.Equal(matchStringFromQuery(`title:budget`), `title : "budget"`)
+	xt.Equal(
+		matchStringFromQuery(`cat title:budg* -text:"quarterly report"`),
+		`NEAR("cat", 15) AND title : "budg"* NOT (txt : "quarterly report")`,
+	)
+	xt.Equal(
+		matchStringFromQuery(`title:(invoice OR receipt)`),
+		`title : "invoice" OR title : "receipt"`,
+	)
+}
+
+func TestMatchString_Modes(t *testing.T) {
+	xt := xt.X(t)
+
+	all := matchOptions{mode: protocol.MatchAll}
+	any := matchOptions{mode: protocol.MatchAny}
+	near := matchOptions{mode: protocol.MatchProximity, distance: 3}
+
+	xt.Equal(matchStringFromQueryWithOptions(`cat dog -horse`, all), `"cat" AND "dog" NOT ("horse")`)
+	xt.Equal(matchStringFromQueryWithOptions(`cat dog -horse`, any), `("cat" OR "dog") NOT ("horse")`)
+	xt.Equal(matchStringFromQueryWithOptions(`cat dog -horse`, near), `NEAR("cat" "dog", 3) NOT ("horse")`)
+	xt.Equal(
+		matchStringFromQueryWithOptions(`cat title:dog (pony OR horse)`, any),
+		`"cat" OR title : "dog" OR ("pony" OR "horse")`,
+	)
+}
+
+func TestMatchString_NearOperator(t *testing.T) {
+	xt := xt.X(t)
+
+	all := matchOptions{mode: protocol.MatchAll}
+
+	xt.Equal(matchStringFromQuery(`cat NEAR/3 dog`), `NEAR("cat" "dog", 3)`)
+	xt.Equal(matchStringFromQuery(`title:cat NEAR/3 title:dog*`), `title : NEAR("cat" "dog"*, 3)`)
+	xt.Equal(
+		matchStringFromQueryWithOptions(`cat NEAR/3 dog NEAR/3 horse pony`, all),
+		`"pony" AND (NEAR("cat" "dog" "horse", 3))`,
+	)
+	xt.Equal(
+		matchStringFromQuery(`cat NEAR/3 (dog OR pony)`),
+		`"cat" AND ("dog" OR "pony")`,
+	)
+}
+
+func TestMatchString_Fuzzy(t *testing.T) {
+	xt := xt.X(t)
+
+	neighbours := map[string][]string{
+		"recieve": {"receive", "relieve"},
+		"draft":   {"drift"},
+	}
+	expand := func(query string, all bool) string {
+		expression := ReduceExpression(ParseExpression(query))
+		return expressionToMatchString(expandFuzzy(expression, neighbours, all), defaultMatchOptions)
+	}
+
+	xt.Equal(expand(`recieve~ invoice`, false), `NEAR("invoice", 15) AND ("recieve" OR "receive" OR "relieve")`)
+	xt.Equal(expand(`invoice -draft~`, false), `NEAR("invoice", 15) NOT (("draft" OR "drift"))`)
+	xt.Equal(expand(`recieve draft*`, false), `NEAR("recieve" "draft"*, 15)`)
+	xt.Equal(expand(`recieve draft*`, true), `NEAR("draft"*, 15) AND ("recieve" OR "receive" OR "relieve")`)
+}
+
+func addTestDocuments(t *testing.T, db *database, texts map[string]string) {
+	docs := []protocol.Document{}
+	for id, text := range texts {
+		docs = append(docs, protocol.Document{
+			ID:      protocol.DocumentID(id),
+			Updated: time.Now(),
+			Title:   id,
+			Text:    text,
+			Alive:   true,
+		})
+	}
+	err := db.addDocumentUpdates(context.Background(), "test", docs)
+	if err != nil {
+		t.Fatalf("Failed to add documents: %v", err)
+	}
+}
+
+func searchTestDocuments(t *testing.T, db *database, query string) []string {
+	return searchTestDocumentsWithMode(t, db, query, protocol.MatchProximity, 0)
+}
+
+func searchTestDocumentsWithMode(
+	t *testing.T, db *database, query string, mode protocol.MatchMode, distance uint16,
+) []string {
+	expression := ReduceExpression(ParseExpression(query))
+	result, err := db.search(context.Background(), expression, protocol.SearchRequest{
+		Spaces:        []string{"test"},
+		PageLimit:     100,
+		MatchMode:     mode,
+		MatchDistance: distance,
+	})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	ids := []string{}
+	for _, hit := range result.Hits {
+		ids = append(ids, string(hit.ID))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSearch_OrGroups(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	addTestDocuments(t, setup.db, map[string]string{
+		"a": "the monthly invoice for electricity",
+		"b": "a receipt for the groceries",
+		"c": "draft invoice, not sent yet",
+		"d": "nothing to see here",
+	})
+
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `(invoice OR receipt) -draft`), []string{"a", "b"})
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `invoice OR nothing`), []string{"a", "c", "d"})
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `invoice -(draft OR monthly)`), []string{})
+}
+
+func TestSearch_FieldScope(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	addTestDocuments(t, setup.db, map[string]string{
+		"budget": "quarterly report for the team",
+		"report": "the budget is tight",
+	})
+
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `title:budget`), []string{"budget"})
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `text:budget`), []string{"report"})
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `title:budg*`), []string{"budget"})
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `budget -title:budget`), []string{"report"})
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `text:"quarterly report"`), []string{"budget"})
+}
+
+func TestSearch_MatchModes(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	addTestDocuments(t, setup.db, map[string]string{
+		"close":   "the budget report",
+		"distant": "budget one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen sixteen seventeen eighteen report",
+		"partial": "only a budget here",
+	})
+
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `budget report`), []string{"close"})
+	xt.DeepEqual(
+		searchTestDocumentsWithMode(t, setup.db, `budget report`, protocol.MatchAll, 0),
+		[]string{"close", "distant"},
+	)
+	xt.DeepEqual(
+		searchTestDocumentsWithMode(t, setup.db, `budget report`, protocol.MatchAny, 0),
+		[]string{"close", "distant", "partial"},
+	)
+	xt.DeepEqual(
+		searchTestDocumentsWithMode(t, setup.db, `budget report`, protocol.MatchProximity, 25),
+		[]string{"close", "distant"},
+	)
+	xt.DeepEqual(searchTestDocuments(t, setup.db, `budget NEAR/1 report`), []string{"close"})
+}
+
+func TestSearch_UpdatedRange(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	now := time.Now()
+	day := 24 * time.Hour
+	docs := []protocol.Document{}
+	for i, id := range []string{"today", "lastweek", "lastyear"} {
+		age := []time.Duration{0, 7 * day, 365 * day}[i]
+		docs = append(docs, protocol.Document{
+			ID:      protocol.DocumentID(id),
+			Updated: now.Add(-age),
+			Title:   id,
+			Text:    "news from the archive",
+			Alive:   true,
+		})
+	}
+	err := setup.db.addDocumentUpdates(context.Background(), "test", docs)
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	snippet := protocol.SnippetText
+	search := func(request protocol.SearchRequest) ([]string, int) {
+		request.Query = "archive"
+		request.Spaces = []string{"test"}
+		request.PageLimit = 1
+		request.Snippet = snippet
+		expression := ReduceExpression(ParseExpression(request.Query))
+		result, err := setup.db.search(context.Background(), expression, request)
+		xt.Nilf(err, "Search failed: %v", err)
+		ids := []string{}
+		for _, hit := range result.Hits {
+			ids = append(ids, string(hit.ID))
+		}
+		return ids, result.TotalHits
+	}
+
+	for _, snippet = range allSnippetModes {
+		_, total := search(protocol.SearchRequest{})
+		xt.Equal(total, 3)
+
+		ids, total := search(protocol.SearchRequest{UpdatedWithin: 30 * day})
+		xt.Equal(total, 2)
+		xt.Equal(len(ids), 1)
+
+		ids, total = search(protocol.SearchRequest{
+			UpdatedFrom: now.Add(-400 * day),
+			UpdatedTo:   now.Add(-day),
+		})
+		xt.Equal(total, 2)
+		xt.Equal(len(ids), 1)
+
+		ids, total = search(protocol.SearchRequest{
+			UpdatedFrom:   now.Add(-400 * day),
+			UpdatedTo:     now.Add(-day),
+			UpdatedWithin: 30 * day,
+		})
+		xt.Equal(total, 1)
+		xt.DeepEqual(ids, []string{"lastweek"})
+	}
+
+	_, err = setup.db.search(context.Background(), ParseExpression("archive"), protocol.SearchRequest{
+		Spaces:      []string{"test"},
+		UpdatedFrom: now,
+		UpdatedTo:   now.Add(-day),
+	})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+}
+
+func TestSearch_SortOrder(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	now := time.Now()
+	day := 24 * time.Hour
+	docs := []protocol.Document{
+		{ID: "relevant", Updated: now.Add(-300 * day), Title: "release notes", Text: "release release release"},
+		{ID: "recent", Updated: now, Title: "changelog", Text: "a new release of the changelog format"},
+		{ID: "middle", Updated: now.Add(-10 * day), Title: "changelog", Text: "one release and other things"},
+	}
+	for i := range docs {
+		docs[i].Alive = true
+	}
+	err := setup.db.addDocumentUpdates(context.Background(), "test", docs)
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	snippet := protocol.SnippetText
+	search := func(request protocol.SearchRequest) []string {
+		request.Spaces = []string{"test"}
+		request.PageLimit = 10
+		request.Snippet = snippet
+		expression := ReduceExpression(ParseExpression("release"))
+		result, err := setup.db.search(context.Background(), expression, request)
+		xt.Nilf(err, "Search failed: %v", err)
+		ids := []string{}
+		for _, hit := range result.Hits {
+			ids = append(ids, string(hit.ID))
+		}
+		return ids
+	}
+
+	for _, snippet = range allSnippetModes {
+		xt.Equal(search(protocol.SearchRequest{})[0], "relevant")
+		xt.DeepEqual(
+			search(protocol.SearchRequest{Sort: protocol.SortByDate}),
+			[]string{"recent", "middle", "relevant"},
+		)
+		xt.Equal(search(protocol.SearchRequest{
+			Sort:            protocol.SortByRecency,
+			RecencyWeight:   100,
+			RecencyHalfLife: day,
+		})[0], "recent")
+	}
+
+	_, err = setup.db.search(context.Background(), ParseExpression("release"), protocol.SearchRequest{
+		Spaces: []string{"test"},
+		Sort:   protocol.SortByRecency,
+	})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+}
+
+func TestSearch_Cursor(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	now := time.Now()
+	docs := []protocol.Document{}
+	for i := 0; i < 50; i++ {
+		docs = append(docs, protocol.Document{
+			ID:      protocol.DocumentID(fmt.Sprintf("doc%02d", i)),
+			Updated: now.Add(-time.Duration(i%7) * time.Hour),
+			Title:   "report",
+			Text:    strings.Repeat("budget ", 1+i%5) + "report",
+			Alive:   true,
+		})
+	}
+	err := setup.db.addDocumentUpdates(context.Background(), "test", docs)
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	// Cursor walks are not limited by the result cap
+	setup.db.resultCap = 20
+	expression := ReduceExpression(ParseExpression("budget"))
+
+	for _, snippet := range allSnippetModes {
+		for _, order := range []protocol.SortOrder{protocol.SortByRank, protocol.SortByDate} {
+			request := protocol.SearchRequest{
+				Spaces:    []string{"test"},
+				PageLimit: 7,
+				Sort:      order,
+				After:     protocol.CursorStart,
+				Snippet:   snippet,
+			}
+			walked := map[protocol.DocumentID]bool{}
+			var last protocol.SearchHit
+			for {
+				result, err := setup.db.search(context.Background(), expression, request)
+				xt.Nilf(err, "Search failed: %v", err)
+				xt.Equal(result.TotalHits, len(docs)-len(walked))
+				if len(result.Hits) == 0 {
+					break
+				}
+				for _, hit := range result.Hits {
+					xt.Assertf(!walked[hit.ID], "hit returned twice")
+					walked[hit.ID] = true
+					if order == protocol.SortByDate {
+						xt.Assertf(!hit.Updated.After(last.Updated) || last.ID == "", "hits out of order")
+					} else {
+						xt.Assertf(hit.Rank >= last.Rank || last.ID == "", "hits out of order")
+					}
+					last = hit
+				}
+				request.After = result.Cursor
+			}
+			xt.Equal(len(walked), len(docs))
+		}
+	}
+
+	_, err = setup.db.search(context.Background(), expression, protocol.SearchRequest{
+		Spaces: []string{"test"},
+		After:  "not a cursor",
+	})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+}
+
+func TestSearch_Explain(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	addTestDocuments(t, setup.db, map[string]string{
+		"a": "the monthly invoice for electricity",
+		"b": "a receipt for the groceries",
+	})
+	_, err := setup.db.wdb.ExecContext(ctx, `insert into stopwords (word, user) values ('the', 1)`)
+	xt.Nil(err)
+	err = SetSynonyms(ctx, setup.db, []Synonyms{{Words: []string{"invoice", "bill"}}})
+	xt.Nil(err)
+
+	s := &searcher{
+		cfg:   setup.config,
+		db:    setup.db,
+		cache: NewCache(time.Second, 1000),
+	}
+	s.cfg.Shard = "1/1"
+
+	response, err := s.parseAndExecute(ctx, protocol.SearchRequest{
+		Spaces:  []string{"test"},
+		Query:   "the invoice OR x",
+		Explain: true,
+	})
+	xt.Nil(err)
+	xt.Equal(len(response.Explanations), 1)
+
+	explanation := response.Explanations[0]
+	xt.Equal(explanation.Shard, "1/1")
+	xt.DeepEqual(explanation.Parsed, []string{"the", "invoice", "x"})
+	xt.DeepEqual(explanation.Reduced, []string{"the", "invoice"})
+	xt.DeepEqual(explanation.Stopwords, []string{"the"})
+	xt.DeepEqual(explanation.Synonyms, map[string][]string{"invoice": {"bill"}})
+	xt.Equal(explanation.Match, `NEAR("the" "invoice", 15)`)
+	xt.Equal(explanation.Strategy, 1)
+	xt.Assert(len(explanation.Timings) > 0)
+
+	err = UpdateSpellfix(ctx, setup.db, 1)
+	xt.Nil(err)
+	response, err = s.parseAndExecute(ctx, protocol.SearchRequest{
+		Spaces:      []string{"test"},
+		Query:       "recepit",
+		Autocorrect: true,
+		Explain:     true,
+	})
+	xt.Nil(err)
+	explanation = response.Explanations[0]
+	xt.DeepEqual(explanation.SpellFixes, map[string]string{"recepit": "receipt"})
+	xt.Assert(explanation.Autocorrected)
+	xt.Equal(explanation.Match, `NEAR("receipt", 15)`)
+
+	response, err = s.parseAndExecute(ctx, protocol.SearchRequest{
+		Spaces: []string{"test"},
+		Query:  "invoice",
+	})
+	xt.Nil(err)
+	xt.Equal(len(response.Explanations), 0)
+}
+
+func TestSearch_RankWeights(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "title", Updated: time.Now(), Title: "budget", Text: "numbers for next year", Alive: true},
+		{ID: "text", Updated: time.Now(), Title: "numbers", Text: "the budget for next year", Alive: true},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	snippet := protocol.SnippetText
+	search := func() []string {
+		result, err := setup.db.search(ctx, ParseExpression("budget"), protocol.SearchRequest{
+			Spaces:    []string{"test"},
+			PageLimit: 10,
+			Snippet:   snippet,
+		})
+		xt.Nilf(err, "Search failed: %v", err)
+		ids := []string{}
+		for _, hit := range result.Hits {
+			ids = append(ids, string(hit.ID))
+		}
+		return ids
+	}
+
+	weights, err := GetRankWeights(ctx, setup.db)
+	xt.Nil(err)
+	xt.Equal(weights["test"], RankWeights{Title: 5, Text: 1})
+
+	for _, snippet = range allSnippetModes {
+		err = SetRankWeights(ctx, setup.db, "test", RankWeights{Title: 5, Text: 1})
+		xt.Nil(err)
+		xt.DeepEqual(search(), []string{"title", "text"})
+
+		err = SetRankWeights(ctx, setup.db, "test", RankWeights{Title: 1, Text: 10})
+		xt.Nil(err)
+		xt.DeepEqual(search(), []string{"text", "title"})
+	}
+
+	err = SetRankWeights(ctx, setup.db, "nope", RankWeights{Title: 1, Text: 1})
+	xt.NotNil(err)
+
+	var decoded RankWeights
+	xt.Nil(decoded.Decode("2.5/1"))
+	xt.Equal(decoded, RankWeights{Title: 2.5, Text: 1})
+	xt.NotNil(decoded.Decode("2"))
+	xt.NotNil(decoded.Decode("-1/1"))
+}
+
+func TestSearch_Boost(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "chatter", Updated: time.Now(), Title: "vacation vacation", Text: "vacation policy vacation", Alive: true},
+		{ID: "handbook", Updated: time.Now(), Title: "handbook", Text: "the vacation policy", Alive: true, Boost: 1},
+		{ID: "demoted", Updated: time.Now(), Title: "vacation", Text: "vacation vacation", Alive: true, Boost: -1},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	for _, snippet := range allSnippetModes {
+		result, err := setup.db.search(ctx, ParseExpression("vacation"), protocol.SearchRequest{
+			Spaces:    []string{"test"},
+			PageLimit: 10,
+			Snippet:   snippet,
+		})
+		xt.Nilf(err, "Search failed: %v", err)
+		ids := []string{}
+		for _, hit := range result.Hits {
+			ids = append(ids, string(hit.ID))
+		}
+		xt.DeepEqual(ids, []string{"handbook", "chatter", "demoted"})
+	}
+}
+
+func TestSearch_Filters(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "budget", Updated: time.Now(), Title: "report", Text: "budget report", Alive: true,
+			Fields: map[string][]string{"tag": {"finance"}, "author": {"anna"}}},
+		{ID: "payroll", Updated: time.Now(), Title: "report", Text: "payroll report", Alive: true,
+			Fields: map[string][]string{"tag": {"finance", "hr"}, "author": {"bert"}}},
+		{ID: "party", Updated: time.Now(), Title: "report", Text: "party report", Alive: true,
+			Fields: map[string][]string{"tag": {"fun"}, "author": {"anna, jr"}}},
+		{ID: "plain", Updated: time.Now(), Title: "report", Text: "plain report", Alive: true},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	tests := []struct {
+		filters  []string
+		expected []string
+	}{
+		{nil, []string{"budget", "party", "payroll", "plain"}},
+		{[]string{"tag=finance"}, []string{"budget", "payroll"}},
+		{[]string{"tag!=finance"}, []string{"party", "plain"}},
+		{[]string{"tag=finance", "tag!=hr"}, []string{"budget"}},
+		{[]string{"author in (bert, \"anna, jr\")"}, []string{"party", "payroll"}},
+		{[]string{"author NOT IN (anna,bert)"}, []string{"party", "plain"}},
+		{[]string{"tag=missing"}, []string{}},
+	}
+
+	for _, snippet := range allSnippetModes {
+		for _, test := range tests {
+			result, err := setup.db.search(ctx, ParseExpression("report"), protocol.SearchRequest{
+				Spaces:    []string{"test"},
+				PageLimit: 10,
+				Filters:   test.filters,
+				Snippet:   snippet,
+			})
+			xt.Nilf(err, "Search failed: %v", err)
+			ids := []string{}
+			for _, hit := range result.Hits {
+				ids = append(ids, string(hit.ID))
+			}
+			sort.Strings(ids)
+			xt.DeepEqualf(ids, test.expected, "filters %v, snippet %v", test.filters, snippet)
+			xt.Equalf(result.TotalHits, len(test.expected), "filters %v, snippet %v", test.filters, snippet)
+		}
+	}
+
+	// Updates replace all fields of a document
+	err = setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "payroll", Updated: time.Now(), Title: "report", Text: "payroll report", Alive: true,
+			Fields: map[string][]string{"tag": {"hr"}}},
+	})
+	xt.Nil(err)
+	result, err := setup.db.search(ctx, ParseExpression("report"), protocol.SearchRequest{
+		Spaces:    []string{"test"},
+		PageLimit: 10,
+		Filters:   []string{"tag=finance"},
+	})
+	xt.Nil(err)
+	xt.Equal(len(result.Hits), 1)
+	xt.Equal(result.Hits[0].ID, protocol.DocumentID("budget"))
+
+	_, err = setup.db.search(ctx, ParseExpression("report"), protocol.SearchRequest{
+		Spaces:    []string{"test"},
+		PageLimit: 10,
+		Filters:   []string{"tag"},
+	})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+}
+
+func TestParseFilter(t *testing.T) {
+	xt := xt.X(t)
+
+	tests := []struct {
+		expression string
+		expected   fieldFilter
+	}{
+		{"tag=finance", fieldFilter{Field: "tag", Values: []string{"finance"}}},
+		{" tag != finance ", fieldFilter{Field: "tag", Values: []string{"finance"}, Exclude: true}},
+		{"author in (a, b)", fieldFilter{Field: "author", Values: []string{"a", "b"}}},
+		{"author not in (a,\"b, c\")", fieldFilter{Field: "author", Values: []string{"a", "b, c"}, Exclude: true}},
+	}
+	for _, test := range tests {
+		filter, err := parseFilter(test.expression)
+		xt.Nilf(err, "parsing %q", test.expression)
+		xt.DeepEqualf(filter, test.expected, "parsing %q", test.expression)
+	}
+
+	for _, invalid := range []string{"", "tag", "tag=", "=value", "tag in ()", "tag in (a,,b)"} {
+		_, err := parseFilter(invalid)
+		xt.Assertf(errors.Is(err, errInvalidQuery), "expected error parsing %q", invalid)
+	}
+}
+
+func TestSearch_Facets(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "budget", Updated: time.Now(), Title: "report", Text: "budget report", Alive: true,
+			Fields: map[string][]string{"tag": {"finance"}, "author": {"anna"}}},
+		{ID: "payroll", Updated: time.Now(), Title: "report", Text: "payroll report", Alive: true,
+			Fields: map[string][]string{"tag": {"finance", "hr"}, "author": {"bert"}}},
+		{ID: "party", Updated: time.Now(), Title: "report", Text: "party report", Alive: true,
+			Fields: map[string][]string{"tag": {"fun"}}},
+		{ID: "other", Updated: time.Now(), Title: "other", Text: "something else", Alive: true,
+			Fields: map[string][]string{"tag": {"finance"}}},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	request := protocol.SearchRequest{
+		Spaces:      []string{"test"},
+		PageLimit:   1,
+		SpaceFacets: true,
+		FieldFacets: []string{"tag", "author", "missing"},
+	}
+
+	for _, snippet := range allSnippetModes {
+		request.Snippet = snippet
+		result, err := setup.db.search(ctx, ParseExpression("report"), request)
+		xt.Nilf(err, "Search failed: %v", err)
+		xt.Equal(len(result.Hits), 1)
+		xt.DeepEqual(result.Facets, protocol.SearchFacets{
+			Spaces: map[string]int{"test": 3},
+			Fields: map[string]map[string]int{
+				"tag":     {"finance": 2, "hr": 1, "fun": 1},
+				"author":  {"anna": 1, "bert": 1},
+				"missing": {},
+			},
+		})
+	}
+
+	filtered := request
+	filtered.Filters = []string{"tag=hr"}
+	filtered.SpaceFacets = false
+	result, err := setup.db.search(ctx, ParseExpression("report"), filtered)
+	xt.Nil(err)
+	xt.Assert(result.Facets.Spaces == nil)
+	xt.DeepEqual(result.Facets.Fields["tag"], map[string]int{"finance": 1, "hr": 1})
+
+	// Facets count all matches, also on cursor pages
+	walk := request
+	walk.After = protocol.CursorStart
+	result, err = setup.db.search(ctx, ParseExpression("report"), walk)
+	xt.Nil(err)
+	walk.After = result.Cursor
+	result, err = setup.db.search(ctx, ParseExpression("report"), walk)
+	xt.Nil(err)
+	xt.Equal(len(result.Hits), 1)
+	xt.Equal(result.Facets.Spaces["test"], 3)
+	xt.DeepEqual(result.Facets.Fields["tag"], map[string]int{"finance": 2, "hr": 1, "fun": 1})
+
+	// Facets are counted over the capped matches
+	setup.db.resultCap = 1
+	result, err = setup.db.search(ctx, ParseExpression("report"), request)
+	xt.Nil(err)
+	xt.Assert(result.Capped)
+	xt.Equal(result.Facets.Spaces["test"], 2)
+
+	plain := request
+	plain.SpaceFacets = false
+	plain.FieldFacets = nil
+	result, err = setup.db.search(ctx, ParseExpression("report"), plain)
+	xt.Nil(err)
+	xt.DeepEqual(result.Facets, protocol.SearchFacets{})
+}
+
+func TestSearch_Highlight(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "budget", Updated: time.Now(), Title: "The yearly budget report", Alive: true,
+			Text: "This is the budget. It has a quarterly report, and an annual report."},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	request := protocol.SearchRequest{
+		Spaces:         []string{"test"},
+		PageLimit:      10,
+		HighlightOpen:  "<b>",
+		HighlightClose: "</b>",
+	}
+
+	for _, snippet := range allSnippetModes {
+		request.Snippet = snippet
+		result, err := setup.db.search(ctx, ParseExpression("report"), request)
+		xt.Nilf(err, "Search failed: %v", err)
+		xt.Equal(len(result.Hits), 1)
+		xt.Equal(result.Hits[0].Title, "The yearly budget <b>report</b>")
+		if snippet == protocol.SnippetTitle {
+			xt.Equal(result.Hits[0].Snippet, result.Hits[0].Title)
+		}
+
+		result, err = setup.db.search(ctx, ParseExpression("annual report -yearly"), request)
+		xt.Nilf(err, "Search failed: %v", err)
+		xt.Equal(len(result.Hits), 0)
+
+		result, err = setup.db.search(ctx, ParseExpression(`"quarterly report" budget`), request)
+		xt.Nilf(err, "Search failed: %v", err)
+		xt.Equal(len(result.Hits), 1)
+		// Only phrase instances within the "near" group in the text match
+		xt.Equal(result.Hits[0].Title, "The yearly budget report")
+		if snippet == protocol.SnippetText {
+			xt.Equal(result.Hits[0].Snippet,
+				"…the <b>budget</b>. It has a <b>quarterly</b> <b>report</b>, and an annual report")
+		}
+	}
+
+	plain := request
+	plain.Snippet = protocol.SnippetText
+	plain.HighlightOpen = ""
+	plain.HighlightClose = ""
+	result, err := setup.db.search(ctx, ParseExpression(`"quarterly report" budget`), plain)
+	xt.Nil(err)
+	xt.Equal(result.Hits[0].Title, "")
+	xt.Equal(result.Hits[0].Snippet, "…the budget. It has a quarterly report, and an annual report")
+}
+
+func TestSearch_Snippets(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "contract", Updated: time.Now(), Title: "Rental contract", Alive: true,
+			Text: "The tenant pays the rent monthly. Repairs are made by the landlord " +
+				"within a reasonable time. The tenant may not sublet without consent, " +
+				"and the rent is adjusted yearly."},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	snippet := func(request protocol.SearchRequest) string {
+		request.Spaces = []string{"test"}
+		request.PageLimit = 10
+		result, err := setup.db.search(ctx, ParseExpression("rent"), request)
+		xt.Nilf(err, "Search failed: %v", err)
+		xt.Equal(len(result.Hits), 1)
+		return result.Hits[0].Snippet
+	}
+
+	xt.Equal(snippet(protocol.SearchRequest{}),
+		"…the rent monthly. Repairs are made by the landlord within…")
+	xt.Equal(snippet(protocol.SearchRequest{SnippetTokens: 3}), "…the rent monthly…")
+	xt.Equal(snippet(protocol.SearchRequest{SnippetTokens: 3, SnippetFragments: 3}),
+		"…the rent monthly… …the rent is…")
+	xt.Equal(snippet(protocol.SearchRequest{SnippetTokens: 3, SnippetFragments: 2, HighlightOpen: "*"}),
+		"…the *rent monthly… …the *rent is…")
+	xt.Equal(snippet(protocol.SearchRequest{SnippetTokens: 100, SnippetFragments: 2}),
+		"…the rent monthly. Repairs are made by the landlord "+
+			"within a reasonable time. The tenant may not sublet without consent, "+
+			"and the rent is adjusted yearly")
+	xt.Equal(snippet(protocol.SearchRequest{Snippet: protocol.SnippetTitle}), "Rental contract")
+	xt.Equal(snippet(protocol.SearchRequest{Snippet: protocol.SnippetNone}), "")
+
+	_, err = setup.db.search(ctx, ParseExpression("rent"), protocol.SearchRequest{
+		Spaces:  []string{"test"},
+		Snippet: 17,
+	})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+}
+
+func TestSuggest(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "1", Updated: time.Now(), Title: "budget", Text: "the budget for a bucket", Alive: true},
+		{ID: "2", Updated: time.Now(), Title: "budget", Text: "budget bucket bulk", Alive: true},
+		{ID: "3", Updated: time.Now(), Title: "bulk", Text: "bulk burst of bucket", Alive: true},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	terms := func(request protocol.SuggestRequest) []string {
+		suggestions, err := setup.db.suggest(ctx, request)
+		xt.Nilf(err, "Suggest failed: %v", err)
+		result := []string{}
+		for _, suggestion := range suggestions {
+			result = append(result, fmt.Sprintf("%s:%d", suggestion.Term, suggestion.Count))
+		}
+		return result
+	}
+
+	xt.DeepEqual(terms(protocol.SuggestRequest{Prefix: "bu"}), []string{"bucket:3", "budget:2", "bulk:2", "burst:1"})
+	xt.DeepEqual(terms(protocol.SuggestRequest{Prefix: "BU", Limit: 2}), []string{"bucket:3", "budget:2"})
+	xt.DeepEqual(terms(protocol.SuggestRequest{Prefix: "bud", Spaces: []string{"test"}}), []string{"budget:2"})
+	xt.DeepEqual(terms(protocol.SuggestRequest{Prefix: "bu", Spaces: []string{"nope"}}), []string{})
+	xt.DeepEqual(terms(protocol.SuggestRequest{Prefix: "x"}), []string{})
+
+	// Words are suggested as written, not stemmed
+	err = setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "4", Updated: time.Now(), Title: "invoices", Text: "invoice invoicing", Alive: true},
+	})
+	xt.Nil(err)
+	xt.DeepEqual(terms(protocol.SuggestRequest{Prefix: "invo"}), []string{"invoice:1", "invoices:1", "invoicing:1"})
+	xt.DeepEqual(terms(protocol.SuggestRequest{Prefix: "invo", Spaces: []string{"test"}}), []string{"invoice:1", "invoices:1", "invoicing:1"})
+
+	_, err = setup.db.suggest(ctx, protocol.SuggestRequest{Prefix: " "})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+}
+
+func TestSimilarTerms(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "1", Updated: time.Now(), Title: "budget report", Text: "a budget of budget, bucket and rent zebra report", Alive: true},
+		{ID: "2", Updated: time.Now(), Title: "budget", Text: "budget bulk", Alive: true},
+		{ID: "3", Updated: time.Now(), Title: "bulk", Text: "bucket burst", Alive: true},
+		{ID: "4", Updated: time.Now(), Title: "rent", Text: "rent", Alive: true},
+		{ID: "5", Updated: time.Now(), Title: "report", Text: "report", Alive: true},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	terms, err := setup.db.similarTerms(ctx, protocol.SimilarRequest{Space: "test", ID: "1"})
+	xt.Nilf(err, "Similar terms failed: %v", err)
+	xt.DeepEqual(terms, []string{"budget", "report", "bucket", "rent"})
+
+	terms, err = setup.db.similarTerms(ctx, protocol.SimilarRequest{Space: "test", ID: "1", Limit: 2})
+	xt.Nil(err)
+	xt.DeepEqual(terms, []string{"budget", "report"})
+
+	terms, err = setup.db.similarTerms(ctx, protocol.SimilarRequest{Space: "test", ID: "missing"})
+	xt.Nil(err)
+	xt.DeepEqual(terms, []string{})
+
+	_, err = setup.db.similarTerms(ctx, protocol.SimilarRequest{Space: "test"})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+
+	// Terms are returned unstemmed, finding the same documents when searched
+	err = setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "6", Updated: time.Now(), Title: "generation", Text: "generations of general generations", Alive: true},
+		{ID: "7", Updated: time.Now(), Title: "generations", Text: "next", Alive: true},
+	})
+	xt.Nil(err)
+	terms, err = setup.db.similarTerms(ctx, protocol.SimilarRequest{Space: "test", ID: "6", Limit: 1})
+	xt.Nil(err)
+	xt.DeepEqual(terms, []string{"generations"})
+	result, err := setup.db.search(ctx, ParseExpression(`"generations"`), protocol.SearchRequest{
+		Spaces:    []string{"test"},
+		PageLimit: 10,
+	})
+	xt.Nil(err)
+	xt.Equal(result.TotalHits, 2)
+
+	for _, snippet := range allSnippetModes {
+		result, err := setup.db.search(ctx, ParseExpression(`"budget" OR "rent"`), protocol.SearchRequest{
+			Spaces:    []string{"test"},
+			PageLimit: 10,
+			Snippet:   snippet,
+			Exclude:   []protocol.DocumentLocator{{Space: "test", ID: "1"}, {Space: "other", ID: "2"}},
+		})
+		xt.Nilf(err, "Search failed: %v", err)
+		ids := []string{}
+		for _, hit := range result.Hits {
+			ids = append(ids, string(hit.ID))
+		}
+		sort.Strings(ids)
+		xt.DeepEqual(ids, []string{"2", "4"})
+		xt.Equal(result.TotalHits, 2)
+	}
+}
+
+func TestSearch_GlobalTermStatistics(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+	defer auxiliary.SetTermStatistics(nil)
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "1", Updated: time.Now(), Title: "one", Text: "budget", Alive: true},
+		{ID: "2", Updated: time.Now(), Title: "two", Text: "rent", Alive: true},
+		{ID: "3", Updated: time.Now(), Title: "three", Text: "rent and more", Alive: true},
+		{ID: "4", Updated: time.Now(), Title: "four", Text: "rent and less", Alive: true},
+	})
+	xt.Nilf(err, "Failed to add documents: %v", err)
+
+	stats, err := setup.db.getTermStatistics(ctx, 0)
+	xt.Nil(err)
+	xt.Equal(stats.Documents, int64(4))
+	xt.Equal(stats.Terms["rent"], int64(3))
+	xt.Equal(stats.Terms["budget"], int64(1))
+	xt.Equal(stats.Tokens, int64(12))
+
+	// Only the most common terms are kept, all tokens are counted
+	common, err := setup.db.getTermStatistics(ctx, 2)
+	xt.Nil(err)
+	xt.Equal(len(common.Terms), 2)
+	xt.Equal(common.Terms["rent"], int64(3))
+	xt.Equal(common.Tokens, stats.Tokens)
+
+	// Without cluster statistics, ranks are the same as using bm25
+	var ranks []struct {
+		Local  float64
+		Global float64
+	}
+	err = setup.db.rdb.SelectContext(ctx, &ranks, `
+	select bm25(fts, 2, 1) as local, globalbm25(fts, 0, 0, 2, 1) as global
+	from fts where fts match 'budget OR rent'
+	`)
+	xt.Nil(err)
+	xt.Equal(len(ranks), 4)
+	for _, rank := range ranks {
+		xt.Equal(rank.Local, rank.Global)
+	}
+
+	search := func() []string {
+		result, err := setup.db.search(ctx, ParseExpression("budget OR rent"), protocol.SearchRequest{
+			Spaces:    []string{"test"},
+			PageLimit: 10,
+		})
+		xt.Nilf(err, "Search failed: %v", err)
+		ids := []string{}
+		for _, hit := range result.Hits {
+			ids = append(ids, string(hit.ID))
+		}
+		return ids
+	}
+
+	// The locally rare term ranks highest
+	xt.DeepEqual(search()[0], "1")
+
+	// In the cluster, "budget" is common and "rent" is rare
+	auxiliary.SetTermStatistics(&auxiliary.TermStatistics{
+		Documents: 1000,
+		Tokens:    3000,
+		Terms:     map[string]int64{"budget": 800, "rent": 3},
+	})
+	xt.DeepEqual(search(), []string{"2", "3", "4", "1"})
+}
+
+func TestSearch_Fuzzy(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	addTestDocuments(t, setup.db, map[string]string{
+		"1": "the color of money, and a lot of other words making this a long text",
+		"2": "colour colour colour",
+		"3": "nothing to see here",
+	})
+	err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{
+		{ID: "boosted", Updated: time.Now(), Title: "boosted", Text: "colour", Alive: true, Boost: 5000},
+	})
+	xt.Nil(err)
+	err = UpdateSpellfix(ctx, setup.db, 1)
+	xt.Nil(err)
+
+	search := func(query string, fuzzy bool) []protocol.SearchHit {
+		result, err := setup.db.search(ctx, ReduceExpression(ParseExpression(query)), protocol.SearchRequest{
+			Spaces:    []string{"test"},
+			PageLimit: 10,
+			Fuzzy:     fuzzy,
+		})
+		xt.Nilf(err, "Search failed: %v", err)
+		return result.Hits
+	}
+
+	hits := search("color", false)
+	xt.Equal(len(hits), 1)
+	xt.False(hits[0].Fuzzy)
+
+	// The exact match sorts first, even though the neighbours are
+	// denser or heavily boosted
+	for _, hits := range [][]protocol.SearchHit{search("color~", false), search("color", true)} {
+		xt.Equal(len(hits), 3)
+		xt.Equal(hits[0].ID, protocol.DocumentID("1"))
+		xt.False(hits[0].Fuzzy)
+		xt.Equal(hits[1].ID, protocol.DocumentID("boosted"))
+		xt.True(hits[1].Fuzzy)
+		xt.True(hits[2].Fuzzy)
+	}
+
+	// Unknown terms match their neighbours only
+	hits = search("colr~", false)
+	xt.Equal(len(hits), 3)
+
+	// Fuzzy terms cannot be combined with NEAR
+	_, err = setup.db.search(ctx, ReduceExpression(ParseExpression("color~ NEAR/2 money")), protocol.SearchRequest{
+		Spaces:    []string{"test"},
+		PageLimit: 10,
+	})
+	xt.Assert(errors.Is(err, errInvalidQuery))
+
+	// Fuzzy search mode keeps NEAR groups exact
+	hits = search("color NEAR/2 money", true)
+	xt.Equal(len(hits), 1)
+	xt.False(hits[0].Fuzzy)
+
+	neighbours, err := setup.db.fuzzyNeighbours(ctx, ParseQuery("color~ money"), false)
+	xt.Nil(err)
+	xt.DeepEqual(neighbours, map[string][]string{"color": {"colour"}})
+}
+
+func TestSearch_Corrections(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	addTestDocuments(t, setup.db, map[string]string{
+		"1": "band",
+		"2": "bank",
+		"3": "bank",
+		"4": "bank",
+		"5": "bang",
+		"6": "bang",
+	})
+	err := UpdateSpellfix(ctx, setup.db, 1)
+	xt.Nil(err)
+
+	s := &searcher{
+		cfg:   setup.config,
+		db:    setup.db,
+		cache: NewCache(time.Second, 1000),
+	}
+	s.cfg.Spelling.FewHits = 1
+
+	search := func(query string, autocorrect bool) protocol.SearchResult {
+		response, err := s.parseAndExecute(ctx, protocol.SearchRequest{
+			Spaces:      []string{"test"},
+			Query:       query,
+			PageLimit:   10,
+			Autocorrect: autocorrect,
+		})
+		xt.Nil(err)
+		return response.Result
+	}
+
+	// Queries with few hits get the closest correction, if finding more hits.
+	// Equally close corrections are ordered by dictionary rank.
+	result := search("band", true)
+	xt.Equal(result.TotalHits, 1)
+	xt.DeepEqual(result.Corrections, []protocol.Correction{
+		{Query: "bank", Distance: 75, Hits: 3},
+	})
+	xt.Equal(result.Respelt, "bank")
+	xt.Equal(len(result.Hits), 1)
+
+	// Queries with more hits get no corrections
+	result = search("bank", true)
+	xt.Equal(result.TotalHits, 3)
+	xt.Equal(len(result.Corrections), 0)
+	xt.Equal(result.Respelt, "")
+
+	// Corrections are counted without searching
+	hits, err := setup.db.countHits(ctx, ReduceExpression(ParseExpression("bank OR bang")), protocol.SearchRequest{
+		Spaces: []string{"test"},
+	})
+	xt.Nil(err)
+	xt.Equal(hits, 5)
+
+	// Queries without hits are autocorrected using the best correction
+	result = search("banl", true)
+	xt.Equal(len(result.Corrections), 1)
+	xt.Equal(result.TotalHits, result.Corrections[0].Hits)
+	xt.Equal(result.Respelt, result.Corrections[0].Query)
+}
+
+func TestSearch_PhraseCorrections(t *testing.T) {
+	setup := getTestSetup(t)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	addTestDocuments(t, setup.db, map[string]string{
+		"1": "the quarterly report is late",
+		"2": "quarterly budget report",
+		"3": "annual report",
+	})
+	err := UpdateSpellfix(ctx, setup.db, 1)
+	xt.Nil(err)
+
+	s := &searcher{
+		cfg:   setup.config,
+		db:    setup.db,
+		cache: NewCache(time.Second, 1000),
+	}
+
+	search := func(query string) protocol.SearchResult {
+		response, err := s.parseAndExecute(ctx, protocol.SearchRequest{
+			Spaces:      []string{"test"},
+			Query:       query,
+			PageLimit:   10,
+			Autocorrect: true,
+		})
+		xt.Nil(err)
+		return response.Result
+	}
+
+	result := search(`"quartrely reprot" -text:"annual reprot"`)
+	xt.Equal(result.Respelt, `"quarterly report" -text:"annual reprot"`)
+	xt.Equal(len(result.Hits), 1)
+	xt.Equal(result.Hits[0].ID, protocol.DocumentID("1"))
+
+	result = search(`text:"budget reprot" quarterly`)
+	xt.Equal(result.Respelt, `text:"budget report" quarterly`)
+	xt.Equal(len(result.Hits), 1)
+	xt.Equal(result.Hits[0].ID, protocol.DocumentID("2"))
+
+	// Respelt phrases not found in the index are not offered
+	result = search(`"late reprot"`)
+	xt.Equal(len(result.Corrections), 0)
+	xt.Equal(result.Respelt, "")
+
+	noStopwords := func(string) bool { return false }
+	candidates, err := setup.db.phraseCandidates(ctx, Phrase{Text: "late reprot"}, noStopwords, 3)
+	xt.Nil(err)
+	xt.DeepEqual(candidates, []spellingCandidate{{text: "late reprot"}})
+	candidates, err = setup.db.phraseCandidates(ctx, Phrase{Text: "Annual  reprot"}, noStopwords, 3)
+	xt.Nil(err)
+	xt.Equal(len(candidates), 1)
+	xt.Equal(candidates[0].text, "Annual report")
+}
@@ -0,0 +1,165 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+const maxSimilarTerms = 100
+
+// Shorter terms are rarely distinctive, and often noise
+const minSimilarTermLength = 3
+
+// similarTerms returns the most distinctive terms of a stored document,
+// ranked by tf-idf. Term frequencies are counted by tokenizing the stored
+// title and text, and document frequencies are read from the index statistics.
+// Terms only found in the document itself cannot match any other document,
+// and are skipped. Returns no terms for unknown documents.
+//
+// Terms are returned as their most frequent surface form in the document,
+// since stems are not stable when stemmed again by a search.
+func (db *database) similarTerms(ctx context.Context, request protocol.SimilarRequest) ([]string, error) {
+	if request.Space == "" || request.ID == "" {
+		return nil, fmt.Errorf("%w: missing document space or ID", errInvalidQuery)
+	}
+	limit := int(request.Limit)
+	if limit == 0 {
+		limit = protocol.DefaultSimilarTerms
+	}
+	limit = min(limit, maxSimilarTerms)
+
+	// Both indexes tokenize the same way, apart from stemming,
+	// listing the terms and their surface forms in the same order
+	var termLists struct {
+		Terms    string
+		Surfaces string
+	}
+	err := db.rdb.GetContext(ctx, &termLists, `
+	with doc as (
+		select id from docs
+		join spaces using(spaceID)
+		where space = ? and docID = ? and alive
+	)
+	select
+		(select termlist(fts) from fts where rowid = doc.id) as terms,
+		(select termlist(fts_surface) from fts_surface where rowid = doc.id) as surfaces
+	from doc
+	`, request.Space, request.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	termList := strings.Fields(termLists.Terms)
+	surfaceList := strings.Fields(termLists.Surfaces)
+	if len(termList) != len(surfaceList) {
+		return nil, fmt.Errorf("document terms and surface forms differ")
+	}
+
+	frequencies := map[string]int{}
+	surfaceFrequencies := map[string]map[string]int{}
+	for i, term := range termList {
+		if utf8.RuneCountInString(term) >= minSimilarTermLength {
+			frequencies[term]++
+			if surfaceFrequencies[term] == nil {
+				surfaceFrequencies[term] = map[string]int{}
+			}
+			surfaceFrequencies[term][surfaceList[i]]++
+		}
+	}
+	if len(frequencies) == 0 {
+		return []string{}, nil
+	}
+
+	terms := make([]string, 0, len(frequencies))
+	for term := range frequencies {
+		terms = append(terms, term)
+	}
+	termsJSON, err := json.Marshal(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	var numDocs int
+	err = db.rdb.GetContext(ctx, &numDocs, `select count(*) from docs where alive`)
+	if err != nil {
+		return nil, err
+	}
+
+	var documentFrequencies []struct {
+		Term  string
+		Count int
+	}
+	err = db.rdb.SelectContext(ctx, &documentFrequencies, `
+	select term, doc as count from fts_rows
+	where term in (select value from json_each(?))
+	and term not in (select word from stopwords)
+	`, string(termsJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	type scoredTerm struct {
+		term  string
+		score float64
+	}
+	var scored []scoredTerm
+	for _, df := range documentFrequencies {
+		if df.Count < 2 {
+			continue
+		}
+		idf := math.Log(float64(max(numDocs, df.Count)) / float64(df.Count))
+		scored = append(scored, scoredTerm{df.Term, float64(frequencies[df.Term]) * idf})
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].term < scored[j].term
+	})
+
+	result := []string{}
+	for _, term := range scored {
+		if len(result) == limit || term.score <= 0 {
+			break
+		}
+		result = append(result, mostFrequent(surfaceFrequencies[term.term]))
+	}
+	return result, nil
+}
+
+// mostFrequent returns the most frequent of the counted words,
+// the first in alphabetical order on ties
+func mostFrequent(counts map[string]int) string {
+	var best string
+	for word, count := range counts {
+		if best == "" || count > counts[best] || count == counts[best] && word < best {
+			best = word
+		}
+	}
+	return best
+}
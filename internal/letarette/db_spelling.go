@@ -17,51 +17,170 @@ package letarette
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
-func (db *database) spellFixTerm(ctx context.Context, term string) (string, float32, bool, error) {
+// spellingCandidate is a possible spelling of a phrase,
+// with the edit distance from the original phrase
+type spellingCandidate struct {
+	text     string
+	distance float32
+}
+
+// phraseExists checks if a phrase exists in the index, within the given field if set
+func (db *database) phraseExists(ctx context.Context, phrase string, field string) (bool, error) {
 	var exists bool
-	quotedTerm := fmt.Sprintf("%q", term)
-	err := db.rdb.GetContext(ctx, &exists, `select exists(select rowid from fts where fts match ? limit 1)`, quotedTerm)
+	matchPhrase := phraseToMatchString(Phrase{Text: phrase, Field: field})
+	err := db.rdb.GetContext(ctx, &exists, `select exists(select rowid from fts where fts match ? limit 1)`, matchPhrase)
+	return exists, err
+}
+
+// spellingCandidates looks up the possible spellings of a term, closest first.
+// Terms existing in the index, within the given field if set, are candidates
+// for themselves. Unknown terms are replaced by up to limit spelling dictionary matches.
+func (db *database) spellingCandidates(
+	ctx context.Context, term string, field string, limit int,
+) ([]spellingCandidate, error) {
+	exists, err := db.phraseExists(ctx, term, field)
 	if err != nil {
-		return "", 0, false, err
+		return nil, err
 	}
+
+	var candidates []spellingCandidate
 	if exists {
-		return term, 0, false, nil
+		candidates = append(candidates, spellingCandidate{text: term})
 	}
 
-	fixed := struct {
+	var fixes []struct {
 		Word     string
 		Distance float32
-		Score    int
-	}{}
-	unquotedTerm := strings.TrimSuffix(strings.TrimPrefix(term, `"`), `"`)
-	err = db.rdb.GetContext(ctx, &fixed,
-		`select word, distance, score from speling where word match ? limit 1`, unquotedTerm)
+	}
+	unquotedTerm := unquote(term)
+	err = db.rdb.SelectContext(ctx, &fixes,
+		`select word, distance from speling where word match ? and top = ? and word <> ? limit ?`,
+		unquotedTerm, limit+1, strings.ToLower(unquotedTerm), limit)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return term, 0, false, nil
+		return nil, err
+	}
+	for _, fix := range fixes {
+		candidates = append(candidates, spellingCandidate{text: fix.Word, distance: fix.Distance})
+	}
+
+	if len(candidates) == 0 {
+		candidates = append(candidates, spellingCandidate{text: term})
+	}
+	return candidates, nil
+}
+
+// candidateCombination is one candidate from each of a number
+// of candidate lists, with the summed edit distance
+type candidateCombination struct {
+	texts    []string
+	distance float32
+}
+
+// combineCandidates combines one candidate from each candidate list,
+// keeping the beam combinations with the lowest summed distance, closest first.
+func combineCandidates(lists [][]spellingCandidate, beam int) []candidateCombination {
+	combinations := []candidateCombination{{}}
+	for _, candidates := range lists {
+		var extended []candidateCombination
+		for _, combination := range combinations {
+			texts := combination.texts[:len(combination.texts):len(combination.texts)]
+			for _, candidate := range candidates {
+				extended = append(extended, candidateCombination{
+					texts:    append(texts, candidate.text),
+					distance: combination.distance + candidate.distance,
+				})
+			}
 		}
-		return "", 0, false, err
+		sort.SliceStable(extended, func(a, b int) bool {
+			return extended[a].distance < extended[b].distance
+		})
+		if len(extended) > beam {
+			extended = extended[:beam]
+		}
+		combinations = extended
 	}
+	return combinations
+}
 
-	return fixed.Word, fixed.Distance, true, nil
+// spellingCorrection is a respelt phrase list, with
+// the summed edit distance of all respelt phrases
+type spellingCorrection struct {
+	phrases  []Phrase
+	distance float32
 }
 
-// fixPhraseSpelling tries to spell-fix a list of phrases.
-// Returns a list of possibly fixed phrases, the sum of edit distances of the fixes
-// and a "fixed" status.
-func (db *database) fixPhraseSpelling(ctx context.Context, phrases []Phrase) ([]Phrase, float32, bool, error) {
-	clone := append(phrases[:0:0], phrases...)
-	distances := float32(0.0)
-	fixed := false
+// Number of corrections kept per wanted correction while
+// combining phrase spellings, and returned for validation
+const correctionBeamFactor = 2
+
+// phraseCandidates looks up the possible spellings of a multi-term phrase,
+// closest first. Each term is respelt separately, and the respelt phrases
+// are validated against the index. Phrases existing in the index are
+// candidates for themselves.
+func (db *database) phraseCandidates(
+	ctx context.Context, phrase Phrase, isStopword func(string) bool, limit int,
+) ([]spellingCandidate, error) {
+	terms := strings.Fields(phrase.Text)
+	var termLists [][]spellingCandidate
+	for _, term := range terms {
+		candidates := []spellingCandidate{{text: term}}
+		if !isStopword(term) {
+			var err error
+			candidates, err = db.spellingCandidates(ctx, term, phrase.Field, limit)
+			if err != nil {
+				return nil, err
+			}
+		}
+		termLists = append(termLists, candidates)
+	}
+
+	var candidates []spellingCandidate
+	for _, combination := range combineCandidates(termLists, correctionBeamFactor*limit) {
+		if len(candidates) == limit {
+			break
+		}
+		text := strings.Join(combination.texts, " ")
+		if text == strings.Join(terms, " ") {
+			text = phrase.Text
+		}
+		exists, err := db.phraseExists(ctx, text, phrase.Field)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			candidates = append(candidates, spellingCandidate{text: text, distance: combination.distance})
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = append(candidates, spellingCandidate{text: phrase.Text})
+	}
+	return candidates, nil
+}
 
-	nonStopwords, err := db.stopwordFilterPhrases(ctx, phrases)
+// spellingCorrections combines the possible spellings of all phrases in a
+// phrase list into respelt phrase lists, ordered by summed edit distance.
+// Stopwords, excluded and wildcard phrases are kept as is, and the terms
+// of multi-term phrases are respelt using phraseCandidates.
+// Up to correctionBeamFactor * limit corrections are returned,
+// never including the unchanged phrase list.
+func (db *database) spellingCorrections(
+	ctx context.Context, phrases []Phrase, limit int,
+) ([]spellingCorrection, error) {
+	var terms []Phrase
+	for _, phrase := range phrases {
+		for _, term := range strings.Fields(phrase.Text) {
+			terms = append(terms, Phrase{Text: term})
+		}
+	}
+	nonStopwords, err := db.stopwordFilterPhrases(ctx, terms)
 	if err != nil {
-		return []Phrase{}, 0, false, err
+		return nil, err
 	}
 
 	isStopword := func(phrase string) bool {
@@ -73,24 +192,256 @@ func (db *database) fixPhraseSpelling(ctx context.Context, phrases []Phrase) ([]
 		return true
 	}
 
-	for index, phrase := range phrases {
-		if strings.Contains(phrase.Text, " ") {
-			// Skip multi-term phrases
+	var phraseLists [][]spellingCandidate
+	for _, phrase := range phrases {
+		candidates := []spellingCandidate{{text: phrase.Text}}
+		switch {
+		case phrase.Wildcard || phrase.Exclude:
+		case strings.Contains(phrase.Text, " "):
+			candidates, err = db.phraseCandidates(ctx, phrase, isStopword, limit)
+		case !isStopword(phrase.Text):
+			candidates, err = db.spellingCandidates(ctx, phrase.Text, phrase.Field, limit)
+		}
+		if err != nil {
+			return nil, err
+		}
+		phraseLists = append(phraseLists, candidates)
+	}
+
+	// The unchanged phrase list may be among the combinations
+	beam := correctionBeamFactor * limit
+	var corrections []spellingCorrection
+	for _, combination := range combineCandidates(phraseLists, beam+1) {
+		correction := spellingCorrection{
+			phrases:  append(phrases[:0:0], phrases...),
+			distance: combination.distance,
+		}
+		changed := false
+		for i, text := range combination.texts {
+			changed = changed || text != phrases[i].Text
+			correction.phrases[i].Text = text
+		}
+		if changed && len(corrections) < beam {
+			corrections = append(corrections, correction)
+		}
+	}
+	return corrections, nil
+}
+
+// isFuzzy returns true for phrases to be expanded to their spelling neighbours.
+// Only single, non-wildcard terms can be fuzzy.
+func isFuzzy(phrase Phrase, all bool) bool {
+	return (phrase.Fuzzy || all) && !phrase.Wildcard && !strings.Contains(phrase.Text, " ")
+}
+
+// fuzzyNeighbours looks up the spelling dictionary neighbours of fuzzy phrases,
+// closest first, mapped by term. When all is set, all single terms are fuzzy.
+func (db *database) fuzzyNeighbours(ctx context.Context, phrases []Phrase, all bool) (map[string][]string, error) {
+	neighbours := map[string][]string{}
+	if db.fuzzyTerms <= 0 {
+		return neighbours, nil
+	}
+	for _, phrase := range phrases {
+		if !isFuzzy(phrase, all) {
 			continue
 		}
-		if isStopword(phrase.Text) {
-			// Skip stopwords
+		term := strings.ToLower(unquote(phrase.Text))
+		if _, found := neighbours[term]; found {
 			continue
 		}
-		if fixedPhrase, fixedDistance, phraseFixed, err := db.spellFixTerm(ctx, phrase.Text); err == nil {
-			if phraseFixed {
-				clone[index].Text = fixedPhrase
-				fixed = true
+		var words []string
+		// The term itself is in the top list when known, one more is fetched
+		err := db.rdb.SelectContext(ctx, &words, `
+		select word from speling
+		where word match ? and top = ? and distance <= ? and word <> ?
+		order by distance, score
+		limit ?`,
+			term, db.fuzzyTerms+1, db.fuzzyDistance, term, db.fuzzyTerms)
+		if err != nil {
+			return nil, err
+		}
+		if len(words) > 0 {
+			neighbours[term] = words
+		}
+	}
+	return neighbours, nil
+}
+
+// checkFuzzyNear rejects fuzzy terms in NEAR/n groups, since FTS5
+// "near" groups cannot hold the alternatives of an expanded term
+func checkFuzzyNear(expr Expression) error {
+	for _, operand := range expr.Operands {
+		if expr.Operator == OpNear && !operand.isGroup() && operand.Phrase.Fuzzy {
+			return fmt.Errorf("%w: fuzzy terms cannot be used with NEAR", errInvalidQuery)
+		}
+		if err := checkFuzzyNear(operand); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandFuzzy replaces each fuzzy phrase of an expression tree by an
+// alternative group of the phrase and its spelling neighbours.
+// Phrases in NEAR/n groups are kept as is, also in fuzzy search mode.
+// Returns the original tree when no phrase has any neighbours.
+func expandFuzzy(expr Expression, neighbours map[string][]string, all bool) Expression {
+	if len(neighbours) == 0 || expr.Operator == OpNear {
+		return expr
+	}
+	if expr.isGroup() {
+		expanded := expr
+		expanded.Operands = make([]Expression, len(expr.Operands))
+		for i, operand := range expr.Operands {
+			expanded.Operands[i] = expandFuzzy(operand, neighbours, all)
+		}
+		return expanded
+	}
+
+	phrase := expr.Phrase
+	words := neighbours[strings.ToLower(unquote(phrase.Text))]
+	if !isFuzzy(phrase, all) || len(words) == 0 {
+		return expr
+	}
+	phrase.Fuzzy = false
+	phrase.Exclude = false
+	group := Expression{
+		Operator: OpOr,
+		Exclude:  expr.Phrase.Exclude,
+		Operands: []Expression{{Operator: OpPhrase, Phrase: phrase}},
+	}
+	for _, word := range words {
+		neighbour := phrase
+		neighbour.Text = word
+		group.Operands = append(group.Operands, Expression{Operator: OpPhrase, Phrase: neighbour})
+	}
+	return group
+}
+
+// spellingPass tracks the progress of applying queued document changes
+// to the spelling dictionary. A pass covers all changes queued when it
+// started, and is applied in batches of terms in alphabetical order.
+type spellingPass struct {
+	// Last queued change covered by the pass, zero when no pass is running
+	horizon int64
+	// Last term applied
+	after string
+	// Set when all terms are applied, and covered changes are being dequeued
+	dequeuing bool
+}
+
+// getSpellingLag returns the number of queued document changes
+// not yet applied to the spelling dictionary.
+// Changes are queued and dequeued in rowid order, so the lag is the rowid
+// span of the queue, found without scanning it.
+func (db *database) getSpellingLag(ctx context.Context) (int64, error) {
+	var lag int64
+	err := db.rdb.GetContext(ctx, &lag, `
+		select coalesce(
+			(select rowid from spelling_deltas order by rowid desc limit 1) -
+			(select rowid from spelling_deltas order by rowid limit 1) + 1,
+			0
+		)`)
+	return lag, err
+}
+
+// updateSpellingBatch applies at most batchSize queued term changes to the
+// spelling dictionary, starting or continuing the given pass.
+// Terms occurring at least minCount times in the index are added or re-ranked,
+// others are removed. Returns true when the pass is done.
+func (db *database) updateSpellingBatch(
+	ctx context.Context, pass *spellingPass, minCount int, batchSize int,
+) (bool, error) {
+	tx, err := db.wdb.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if pass.horizon == 0 {
+		err = tx.GetContext(ctx, &pass.horizon, `select coalesce(max(rowid), 0) from spelling_deltas`)
+		if err != nil {
+			return false, err
+		}
+		if pass.horizon == 0 {
+			return true, nil
+		}
+	}
+
+	done := false
+
+	if pass.dequeuing {
+		res, err := tx.ExecContext(ctx, `
+			delete from spelling_deltas where rowid in (
+				select rowid from spelling_deltas
+				where rowid <= ?
+				order by rowid
+				limit ?
+			)`, pass.horizon, batchSize)
+		if err != nil {
+			return false, err
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		done = deleted < int64(batchSize)
+	} else {
+		var changes []struct {
+			Term string
+			Keep bool
+			ID   sql.NullInt64
+			Cnt  int64
+		}
+		err = tx.SelectContext(ctx, &changes, `
+			select
+				changes.term,
+				coalesce(length(changes.term) > 3 and stats.cnt >= ?, 0) as keep,
+				speling_vocab.id,
+				coalesce(stats.cnt, 0) as cnt
+			from spelling_deltas_rows as changes
+			left join fts_rows as stats on stats.term = changes.term
+			left join speling_vocab on speling_vocab.word = changes.term
+			where changes.term > ?
+			order by changes.term
+			limit ?`, minCount, pass.after, batchSize)
+		if err != nil {
+			return false, err
+		}
+
+		for _, change := range changes {
+			switch {
+			case change.Keep && !change.ID.Valid:
+				_, err = tx.ExecContext(ctx, `insert into speling(word, rank) values(?, ?)`, change.Term, change.Cnt)
+			case change.Keep:
+				_, err = tx.ExecContext(ctx, `update speling set rank = ? where rowid = ?`, change.Cnt, change.ID)
+			case change.ID.Valid:
+				_, err = tx.ExecContext(ctx, `delete from speling where rowid = ?`, change.ID)
 			}
-			distances += fixedDistance
-		} else {
-			return []Phrase{}, 0, false, err
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if len(changes) > 0 {
+			pass.after = changes[len(changes)-1].Term
 		}
+		pass.dequeuing = len(changes) < batchSize
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	tx = nil
+
+	if done {
+		*pass = spellingPass{}
 	}
-	return clone, distances, fixed, nil
+	return done, nil
 }
@@ -18,6 +18,8 @@ import (
 	"context"
 	"fmt"
 	"strings"
+
+	"github.com/jmoiron/sqlx"
 )
 
 /*
@@ -54,6 +56,30 @@ func (db *database) stopwordFilterPhrases(ctx context.Context, unfiltered []Phra
 	return filtered, nil
 }
 
+// queryStopwords returns the terms of a phrase list that are dropped
+// by the query tokenizer. Like in the tokenizer, only single term phrases
+// are stopword filtered.
+func (db *database) queryStopwords(ctx context.Context, phrases []Phrase) ([]string, error) {
+	terms := []string{}
+	for _, phrase := range phrases {
+		if phrase.Wildcard || strings.Contains(phrase.Text, " ") {
+			continue
+		}
+		terms = append(terms, strings.ToLower(phrase.Text))
+	}
+	if len(terms) == 0 {
+		return terms, nil
+	}
+
+	query, args, err := sqlx.In(`select distinct word from stopwords where word in (?) order by word`, terms)
+	if err != nil {
+		return nil, err
+	}
+	stopwords := []string{}
+	err = db.rdb.SelectContext(ctx, &stopwords, query, args...)
+	return stopwords, err
+}
+
 func (db *database) updateStopwords(ctx context.Context, stopwordPercentageCutoff float32) error {
 	sql := db.getRawDB()
 
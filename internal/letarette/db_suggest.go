@@ -0,0 +1,84 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+const maxSuggestLimit = 100
+
+// suggest returns the most common indexed words starting with the given prefix.
+// Words are read from the unstemmed surface index, to be completed as typed.
+// Without spaces, document counts are read directly from the index statistics.
+// Counting within spaces requires walking all term instances.
+func (db *database) suggest(ctx context.Context, request protocol.SuggestRequest) ([]protocol.Suggestion, error) {
+	prefix := strings.ToLower(strings.TrimSpace(request.Prefix))
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: empty prefix", errInvalidQuery)
+	}
+	limit := int(request.Limit)
+	if limit == 0 {
+		limit = protocol.DefaultSuggestLimit
+	}
+	limit = min(limit, maxSuggestLimit)
+
+	params := map[string]interface{}{
+		"from":   prefix,
+		"to":     prefix + string(utf8.MaxRune),
+		"spaces": request.Spaces,
+		"limit":  limit,
+	}
+
+	query := `
+	select term, doc as count from fts_surface_rows
+	where term >= :from and term < :to
+	order by doc desc, term
+	limit :limit
+	`
+	if len(request.Spaces) > 0 {
+		query = `
+		select term, count(distinct doc) as count from fts_surface_instances
+		join docs on docs.id = fts_surface_instances.doc
+		join spaces using(spaceID)
+		where term >= :from and term < :to
+		and space in (:spaces)
+		and docs.alive
+		group by term
+		order by count desc, term
+		limit :limit
+		`
+	}
+
+	namedQuery, args, err := sqlx.Named(query, params)
+	if err != nil {
+		return nil, err
+	}
+	namedQuery, args, err = sqlx.In(namedQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []protocol.Suggestion{}
+	err = db.rdb.SelectContext(ctx, &suggestions, namedQuery, args...)
+	return suggestions, err
+}
@@ -17,6 +17,10 @@ package letarette
 import (
 	"context"
 	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
 )
 
 // Synonyms is a named list of words that are considered synonyms
@@ -75,3 +79,50 @@ func SetSynonyms(ctx context.Context, dbo Database, synonyms []Synonyms) error {
 	tx = nil
 	return nil
 }
+
+// querySynonyms returns the synonyms added by the query tokenizer
+// to the terms of a phrase list, mapped by term.
+func (db *database) querySynonyms(ctx context.Context, phrases []Phrase) (map[string][]string, error) {
+	terms := []string{}
+	for _, phrase := range phrases {
+		if phrase.Wildcard {
+			continue
+		}
+		terms = append(terms, strings.FieldsFunc(strings.ToLower(phrase.Text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})...)
+	}
+	synonyms := map[string][]string{}
+	if len(terms) == 0 {
+		return synonyms, nil
+	}
+
+	query, args, err := sqlx.In(`
+	select distinct
+		term.word as term, synonym.word as synonym
+	from
+		synonym_words term
+		join synonym_words synonym on synonym.synonymID =
+			(select synonymID from synonym_words where word = term.word)
+	where
+		term.word in (?) and synonym.word <> term.word
+	order by
+		term, synonym
+	`, terms)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Term    string
+		Synonym string
+	}
+	err = db.rdb.SelectContext(ctx, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		synonyms[row.Term] = append(synonyms[row.Term], row.Synonym)
+	}
+	return synonyms, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"sort"
+
+	"github.com/erkkah/letarette/internal/auxiliary"
+)
+
+// getTermStatistics reads the term statistics of the local index,
+// counted the same way as by the fts5 bm25 function.
+// Only the maxTerms terms found in the most documents are kept,
+// unless maxTerms is zero.
+func (db *database) getTermStatistics(ctx context.Context, maxTerms int) (auxiliary.TermStatistics, error) {
+	stats := auxiliary.TermStatistics{
+		Terms: map[string]int64{},
+	}
+
+	// The fts table holds all documents, alive or not
+	err := db.rdb.GetContext(ctx, &stats.Documents, `select count(*) from docs`)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := db.rdb.QueryContext(ctx, `select term, doc, cnt from fts_rows`)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	type termDocuments struct {
+		term      string
+		documents int64
+	}
+	var terms []termDocuments
+
+	for rows.Next() {
+		var term string
+		var documents int64
+		var tokens int64
+		err = rows.Scan(&term, &documents, &tokens)
+		if err != nil {
+			return stats, err
+		}
+		terms = append(terms, termDocuments{term, documents})
+		stats.Tokens += tokens
+	}
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+
+	if maxTerms > 0 && len(terms) > maxTerms {
+		sort.Slice(terms, func(a, b int) bool {
+			if terms[a].documents != terms[b].documents {
+				return terms[a].documents > terms[b].documents
+			}
+			return terms[a].term < terms[b].term
+		})
+		terms = terms[:maxTerms]
+	}
+	for _, term := range terms {
+		stats.Terms[term.term] = term.documents
+	}
+	return stats, nil
+}
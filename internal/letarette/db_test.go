@@ -15,6 +15,7 @@
 package letarette
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
@@ -61,6 +62,10 @@ func getTestSetup(t *testing.T, compress ...bool) *testSetup {
 	}
 
 	setup.config.Stemmer.Languages = []string{"english"}
+	setup.config.Search.Cap = 10000
+	setup.config.Spelling.Corrections = 3
+	setup.config.Spelling.Fuzzy.Terms = 5
+	setup.config.Spelling.Fuzzy.Distance = 200
 
 	db, err := OpenDatabase(setup.config)
 	if err != nil {
@@ -136,6 +141,45 @@ func TestAddDocument_NewDocument_Compressed(t *testing.T) {
 	xt.Nilf(err, "Failed to add new document")
 }
 
+func TestGetDocument(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		setup := getTestSetup(t, compressed)
+
+		xt := xt.X(t)
+
+		updated := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+		stored := protocol.Document{
+			ID:      "myID",
+			Updated: updated,
+			Title:   "Budget",
+			Text:    "tjo och hej",
+			Alive:   true,
+			Boost:   1.5,
+			Fields:  map[string][]string{"tag": {"finance", "hr"}},
+		}
+		ctx := context.Background()
+		err := setup.db.addDocumentUpdates(ctx, "test", []protocol.Document{stored})
+		xt.Nilf(err, "Failed to add new document")
+
+		doc, found, err := setup.db.getDocument(ctx, "test", "myID")
+		xt.Nil(err)
+		xt.Assert(found)
+		xt.Assert(doc.Updated.Equal(updated))
+		doc.Updated = updated
+		xt.DeepEqual(doc, stored)
+
+		_, found, err = setup.db.getDocument(ctx, "test", "otherID")
+		xt.Nil(err)
+		xt.Assert(!found)
+
+		_, found, err = setup.db.getDocument(ctx, "other", "myID")
+		xt.Nil(err)
+		xt.Assert(!found)
+
+		setup.cleanup()
+	}
+}
+
 func TestCommitInterestList_Empty(t *testing.T) {
 	setup := getTestSetup(t)
 	defer setup.cleanup()
@@ -412,3 +456,124 @@ func TestSetStemmerState_Existing(t *testing.T) {
 
 	xt.DeepEqual(fetched, state)
 }
+
+func TestShardClone_RoundTrip(t *testing.T) {
+	source := getTestSetup(t)
+	defer source.cleanup()
+	target := getTestSetup(t)
+	defer target.cleanup()
+
+	xt := xt.X(t)
+
+	ctx := context.Background()
+	updated := time.Unix(0, time.Now().UnixNano())
+	docs := []protocol.Document{
+		{ID: "handbook", Updated: updated, Title: "Handbook", Text: "official", Alive: true, Boost: 2.5,
+			Fields: map[string][]string{"tag": {"hr", "policy"}, "author": {"board"}}},
+		{ID: "chatter", Updated: updated, Title: "Forum", Text: "chatter", Alive: true},
+	}
+	err := source.db.addDocumentUpdates(ctx, "test", docs)
+	xt.Nil(err)
+
+	var clone bytes.Buffer
+	cloner, err := StartShardClone(ctx, source.db, "1/1", &clone)
+	xt.Nil(err)
+	for more := true; more; {
+		more, err = cloner.Step(ctx)
+		xt.Nil(err)
+	}
+	count, err := cloner.Close()
+	xt.Nil(err)
+	xt.Equal(count, len(docs))
+
+	err = LoadShardClone(ctx, target.db, &clone)
+	xt.Nil(err)
+
+	var loaded []protocol.Document
+	err = target.db.rdb.SelectContext(ctx, &loaded,
+		`select docID as id, title, txt as "text", alive, boost from docs order by docID`)
+	xt.Nil(err)
+	xt.Equal(len(loaded), 2)
+	xt.Equal(loaded[0].ID, protocol.DocumentID("chatter"))
+	xt.Equal(loaded[0].Boost, float32(0))
+	xt.Equal(loaded[1].ID, protocol.DocumentID("handbook"))
+	xt.Equal(loaded[1].Boost, float32(2.5))
+	xt.Equal(loaded[1].Text, "official")
+
+	var fields []string
+	err = target.db.rdb.SelectContext(ctx, &fields,
+		`select docID || ':' || field || '=' || value from fields join docs using (id) order by 1`)
+	xt.Nil(err)
+	xt.DeepEqual(fields, []string{"handbook:author=board", "handbook:tag=hr", "handbook:tag=policy"})
+}
+
+func TestUpdateSpellingBatch(t *testing.T) {
+	setup := getTestSetup(t, true)
+	defer setup.cleanup()
+
+	xt := xt.X(t)
+	ctx := context.Background()
+
+	dictionary := func() []string {
+		var words []string
+		err := setup.db.rdb.SelectContext(ctx, &words, `select word || ':' || rank from speling order by word`)
+		xt.Nil(err)
+		return words
+	}
+	update := func() {
+		var pass spellingPass
+		batches := 0
+		for done := false; !done; batches++ {
+			var err error
+			done, err = setup.db.updateSpellingBatch(ctx, &pass, 2, 1)
+			xt.Nil(err)
+		}
+		xt.Assert(batches > 1)
+		lag, err := setup.db.getSpellingLag(ctx)
+		xt.Nil(err)
+		xt.Equal(lag, int64(0))
+	}
+
+	addTestDocuments(t, setup.db, map[string]string{
+		"1": "budget budget market",
+		"2": "budget",
+	})
+	lag, err := setup.db.getSpellingLag(ctx)
+	xt.Nil(err)
+	xt.Equal(lag, int64(2))
+
+	// Only the queued terms are kept, not the document texts
+	var texts []sql.NullString
+	err = setup.db.rdb.SelectContext(ctx, &texts, `select txt from spelling_deltas`)
+	xt.Nil(err)
+	xt.Equal(len(texts), 2)
+	for _, text := range texts {
+		xt.False(text.Valid)
+	}
+
+	update()
+	xt.DeepEqual(dictionary(), []string{"budget:3"})
+
+	addTestDocuments(t, setup.db, map[string]string{
+		"1": "market market",
+	})
+	update()
+	xt.DeepEqual(dictionary(), []string{"market:2"})
+
+	addTestDocuments(t, setup.db, map[string]string{
+		"3": "market",
+	})
+	update()
+	xt.DeepEqual(dictionary(), []string{"market:3"})
+
+	// Full dictionary updates drop all queued changes
+	addTestDocuments(t, setup.db, map[string]string{
+		"4": "market",
+	})
+	err = UpdateSpellfix(ctx, setup.db, 2)
+	xt.Nil(err)
+	lag, err = setup.db.getSpellingLag(ctx)
+	xt.Nil(err)
+	xt.Equal(lag, int64(0))
+	xt.DeepEqual(dictionary(), []string{"market:4"})
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RankWeights are the bm25 column weights used when ranking hits in a space
+type RankWeights struct {
+	Title float64 `db:"titleWeight"`
+	Text  float64 `db:"textWeight"`
+}
+
+// Decode parses weights on the "title/text" form, like "5/1"
+func (w *RankWeights) Decode(value string) error {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("expected rank weights on the form title/text, got %q", value)
+	}
+	title, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return fmt.Errorf("invalid title weight: %w", err)
+	}
+	text, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid text weight: %w", err)
+	}
+	*w = RankWeights{Title: title, Text: text}
+	return w.validate()
+}
+
+func (w RankWeights) validate() error {
+	if w.Title < 0 || w.Text < 0 {
+		return fmt.Errorf("rank weights cannot be negative")
+	}
+	return nil
+}
+
+func (w RankWeights) String() string {
+	return fmt.Sprintf("%v/%v", w.Title, w.Text)
+}
+
+func setRankWeights(ctx context.Context, db sqlx.ExecerContext, space string, weights RankWeights) error {
+	if err := weights.validate(); err != nil {
+		return err
+	}
+	res, err := db.ExecContext(ctx,
+		`update spaces set titleWeight = ?, textWeight = ? where space = ?`,
+		weights.Title, weights.Text, space,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set rank weights: %w", err)
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows != 1 {
+		return fmt.Errorf("no such space: %q", space)
+	}
+	return nil
+}
+
+// applyRankWeights stores configured rank weights in the spaces table
+func applyRankWeights(db *sqlx.DB, weights map[string]RankWeights) error {
+	for space, spaceWeights := range weights {
+		err := setRankWeights(context.Background(), db, space, spaceWeights)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRankWeights returns the rank weights of all spaces in the index
+func GetRankWeights(ctx context.Context, dbo Database) (map[string]RankWeights, error) {
+	db := dbo.(*database)
+
+	var rows []struct {
+		Space string
+		RankWeights
+	}
+	err := db.rdb.SelectContext(ctx, &rows, `select space, titleWeight, textWeight from spaces order by space`)
+	if err != nil {
+		return nil, err
+	}
+	weights := map[string]RankWeights{}
+	for _, row := range rows {
+		weights[row.Space] = row.RankWeights
+	}
+	return weights, nil
+}
+
+// SetRankWeights changes the rank weights of a space.
+// The new weights are used by all following searches.
+// Cached search results are not affected.
+func SetRankWeights(ctx context.Context, dbo Database, space string, weights RankWeights) error {
+	db := dbo.(*database)
+	return setRankWeights(ctx, db.wdb, space, weights)
+}
@@ -0,0 +1,117 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"time"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+// explainer collects search diagnostics for requests with the explain flag set.
+// All methods are no-ops on a nil explainer, so the search path does not
+// need to check the flag.
+type explainer struct {
+	protocol.SearchExplanation
+	last time.Time
+}
+
+func newExplainer(request protocol.SearchRequest, shard string) *explainer {
+	if !request.Explain {
+		return nil
+	}
+	return &explainer{
+		SearchExplanation: protocol.SearchExplanation{
+			Shard: shard,
+		},
+		last: time.Now(),
+	}
+}
+
+// stage records the time spent since the previous stage
+func (e *explainer) stage(name string) {
+	if e == nil {
+		return
+	}
+	now := time.Now()
+	e.Timings = append(e.Timings, protocol.StageTiming{
+		Stage:    name,
+		Duration: float32(now.Sub(e.last)) / float32(time.Second),
+	})
+	e.last = now
+}
+
+func phraseStrings(phrases []Phrase) []string {
+	result := []string{}
+	for _, phrase := range phrases {
+		result = append(result, phrase.String())
+	}
+	return result
+}
+
+func (e *explainer) query(parsed Expression, reduced Expression) {
+	if e == nil {
+		return
+	}
+	e.Parsed = phraseStrings(parsed.Phrases())
+	e.Reduced = phraseStrings(reduced.Phrases())
+	e.Canonical = CanonicalizeExpression(reduced).String()
+}
+
+// terms looks up how the query tokenizer treats the query terms
+func (e *explainer) terms(ctx context.Context, db *database, expression Expression, fuzzy bool) error {
+	if e == nil {
+		return nil
+	}
+	var err error
+	e.Stopwords, err = db.queryStopwords(ctx, expression.Phrases())
+	if err != nil {
+		return err
+	}
+	e.Synonyms, err = db.querySynonyms(ctx, expression.Phrases())
+	if err != nil {
+		return err
+	}
+	e.FuzzyTerms, err = db.fuzzyNeighbours(ctx, expression.Phrases(), fuzzy)
+	return err
+}
+
+func (e *explainer) search(expression Expression, options matchOptions, strategy int) {
+	if e == nil {
+		return
+	}
+	e.Match = expressionToMatchString(expression, options)
+	e.Strategy = strategy
+}
+
+func (e *explainer) spelling(original []Phrase, fixed []Phrase) {
+	if e == nil {
+		return
+	}
+	e.SpellFixes = map[string]string{}
+	for i := range original {
+		if original[i].Text != fixed[i].Text {
+			e.SpellFixes[original[i].String()] = fixed[i].String()
+		}
+	}
+}
+
+func (e *explainer) explanations() []protocol.SearchExplanation {
+	if e == nil {
+		return nil
+	}
+	return []protocol.SearchExplanation{e.SearchExplanation}
+}
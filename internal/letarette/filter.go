@@ -0,0 +1,94 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// fieldFilter restricts search hits to documents that have, or
+// with Exclude set do not have, any of the given field values.
+type fieldFilter struct {
+	Field   string   `json:"field"`
+	Values  []string `json:"values"`
+	Exclude bool     `json:"exclude"`
+}
+
+var compareFilter = regexp.MustCompile(`^\s*([\w.-]+)\s*(!?=)\s*(.*?)\s*$`)
+var listFilter = regexp.MustCompile(`(?i)^\s*([\w.-]+)\s+(not\s+)?in\s*\((.*)\)\s*$`)
+
+// parseFilter parses one filter expression, like "tag=finance"
+// or "author in (a, b)".
+func parseFilter(expression string) (fieldFilter, error) {
+	var filter fieldFilter
+	var values string
+
+	if match := listFilter.FindStringSubmatch(expression); match != nil {
+		filter.Field = match[1]
+		filter.Exclude = match[2] != ""
+		values = match[3]
+	} else if match := compareFilter.FindStringSubmatch(expression); match != nil {
+		filter.Field = match[1]
+		filter.Exclude = match[2] == "!="
+		values = match[3]
+	} else {
+		return filter, fmt.Errorf("%w: malformed filter %q", errInvalidQuery, expression)
+	}
+
+	reader := csv.NewReader(strings.NewReader(values))
+	reader.TrimLeadingSpace = true
+	parsed, err := reader.Read()
+	if err != nil {
+		return filter, fmt.Errorf("%w: malformed filter values in %q", errInvalidQuery, expression)
+	}
+	for _, value := range parsed {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return filter, fmt.Errorf("%w: empty filter value in %q", errInvalidQuery, expression)
+		}
+		filter.Values = append(filter.Values, value)
+	}
+	return filter, nil
+}
+
+// parseFilters parses a list of filter expressions into the canonical,
+// sorted, JSON form used as search query parameter and cache key.
+func parseFilters(expressions []string) (string, error) {
+	filters := []fieldFilter{}
+	for _, expression := range expressions {
+		filter, err := parseFilter(expression)
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(filter.Values)
+		filters = append(filters, filter)
+	}
+	sort.Slice(filters, func(i, j int) bool {
+		if filters[i].Field != filters[j].Field {
+			return filters[i].Field < filters[j].Field
+		}
+		if filters[i].Exclude != filters[j].Exclude {
+			return filters[j].Exclude
+		}
+		return strings.Join(filters[i].Values, ",") < strings.Join(filters[j].Values, ",")
+	})
+	encoded, err := json.Marshal(filters)
+	return string(encoded), err
+}
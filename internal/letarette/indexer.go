@@ -148,6 +148,8 @@ type indexer struct {
 
 	lastDocumentRequest map[string]time.Time
 
+	spelling spellingPass
+
 	cfg  Config
 	conn *nats.EncodedConn
 	db   *database
@@ -174,6 +176,8 @@ func (idx *indexer) main(atExit func()) {
 			cycleThrottle = time.After(idx.cfg.Index.Wait.EmptyCycle)
 			idx.doHousekeeping()
 		}
+		idx.updateSpelling(totalInterests == 0)
+
 		select {
 		case <-idx.context.Done():
 			atExit()
@@ -482,32 +486,46 @@ func (idx *indexer) doHousekeeping() {
 	}
 	lastHousekeeping = time.Now()
 
-	idx.updateSpelling()
 	idx.updateStopwords()
 }
 
-func (idx *indexer) updateSpelling() {
-	lag, err := GetSpellfixLag(idx.context, idx.db, idx.cfg.Spelling.MinFrequency)
+// updateSpelling applies queued document changes to the spelling dictionary,
+// one batch per cycle, keeping the pass state between cycles.
+// While indexing, passes are started once the lag passes MaxLag.
+// When idle, passes are started for any queued changes.
+func (idx *indexer) updateSpelling(idle bool) {
+	lag, err := idx.db.getSpellingLag(idx.context)
 	if err != nil {
 		if !errors.Is(err, context.Canceled) {
 			logger.Error.Printf("Failed to get spelling index lag: %v", err)
 		}
 		return
 	}
-	if lag < idx.cfg.Spelling.MaxLag {
+	metrics.SpellingLag.Set(lag)
+
+	if lag == 0 {
+		idx.spelling = spellingPass{}
 		return
 	}
-	start := time.Now()
-	logger.Info.Printf("Housekeeping: Updating spelling index")
-	err = UpdateSpellfix(idx.context, idx.db, idx.cfg.Spelling.MinFrequency)
+	passRunning := idx.spelling.horizon != 0
+	if !idle && !passRunning && lag <= int64(idx.cfg.Spelling.MaxLag) {
+		return
+	}
+
+	done, err := idx.db.updateSpellingBatch(
+		idx.context, &idx.spelling, idx.cfg.Spelling.MinFrequency, idx.cfg.Spelling.BatchSize,
+	)
 	if err != nil {
 		if !errors.Is(err, context.Canceled) {
-			logger.Error.Printf("Housekeeping: Failed to update spelling index: %v", err)
+			logger.Error.Printf("Failed to update spelling index: %v", err)
 		}
+		idx.spelling = spellingPass{}
 		return
 	}
-	duration := time.Since(start)
-	logger.Info.Printf("Housekeeping: Done updating spelling index in %v seconds", duration.Seconds())
+	if done {
+		logger.Debug.Printf("Done updating spelling index")
+		metrics.SpellingUpdated.Set(time.Now().Unix())
+	}
 }
 
 func (idx *indexer) updateStopwords() {
@@ -17,7 +17,6 @@ package letarette
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"reflect"
 	"unsafe"
@@ -163,7 +162,7 @@ func CheckIndex(dbo Database) error {
 	return nil
 }
 
-// RebuildIndex rebuilds the fts index from the docs table
+// RebuildIndex rebuilds the fts and surface indexes from the docs table
 func RebuildIndex(dbo Database) error {
 	db := dbo.(*database)
 	sql := db.getRawDB()
@@ -171,6 +170,10 @@ func RebuildIndex(dbo Database) error {
 	if err != nil {
 		return err
 	}
+	_, err = sql.Exec(`insert into fts_surface(fts_surface) values("rebuild");`)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -313,55 +316,8 @@ func CompressIndex(ctx context.Context, dbo Database) error {
 	return nil
 }
 
-// GetSpellfixLag returns how many words in the main index that are not yet in the spelling index.
-func GetSpellfixLag(ctx context.Context, dbo Database, minCount int) (int, error) {
-	db := dbo.(*database)
-	rawdb := db.getRawDB()
-	conn, err := rawdb.Conn(ctx)
-	if err != nil {
-		return 0, err
-	}
-	defer conn.Close()
-
-	_, err = conn.ExecContext(
-		ctx,
-		`create virtual table if not exists temp.stats using fts5vocab(main, 'fts', 'row');`,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	row := conn.QueryRowContext(
-		ctx,
-		`
-		with
-		allwords as(
-			select count(term) as wordcount
-			from temp.stats
-			where length(term) > 3
-			and cnt >= ?
-		),
-		spellwords as (
-			select count(*) as cnt from speling
-		)
-		select (select wordcount from allwords) - (select cnt from spellwords) as lag
-		`,
-		minCount,
-	)
-
-	var lag int
-	err = row.Scan(&lag)
-	if errors.Is(err, sql.ErrNoRows) {
-		return 0, nil
-	}
-	if err != nil {
-		return 0, err
-	}
-	return lag, nil
-}
-
-// UpdateSpellfix updates the spelling table with the top terms
-// from the fts.
+// UpdateSpellfix rebuilds the spelling table with the top terms
+// from the fts, dropping all queued incremental updates.
 func UpdateSpellfix(ctx context.Context, dbo Database, minCount int) error {
 	db := dbo.(*database)
 	sql := db.getRawDB()
@@ -397,6 +353,14 @@ func UpdateSpellfix(ctx context.Context, dbo Database, minCount int) error {
 		return err
 	}
 
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into spelling_deltas(spelling_deltas) values('delete-all')`,
+	)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.ExecContext(
 		ctx,
 		`
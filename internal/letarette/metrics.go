@@ -36,6 +36,10 @@ var metrics = struct {
 	PendingDocs expvar.Int
 	ServedDocs  expvar.Int
 	QueryQueue  expvar.Int
+	// Document changes not yet applied to the spelling dictionary
+	SpellingLag expvar.Int
+	// Unix time when all queued changes were last applied to the spelling dictionary
+	SpellingUpdated expvar.Int
 }{}
 
 type jsonExpvar struct {
@@ -0,0 +1,21 @@
+-- Copyright 2026 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+drop index if exists speling_vocab_word;
+drop trigger if exists spelling_au;
+drop trigger if exists spelling_ad;
+drop trigger if exists spelling_ai;
+drop table if exists spelling_deltas_rows;
+drop table if exists spelling_deltas;
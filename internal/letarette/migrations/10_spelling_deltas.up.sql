@@ -0,0 +1,40 @@
+-- Copyright 2026 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+-- Queue of term changes since the spelling dictionary was updated.
+-- The contentless table only keeps the index, so queued documents
+-- are tokenized but not stored a second time.
+-- Terms of both old and new versions are queued, since either may have
+-- changed frequency in the full text index.
+create virtual table if not exists spelling_deltas using fts5(
+    title, txt, content='', contentless_delete=1, tokenize='snowball', detail='none'
+);
+create virtual table if not exists spelling_deltas_rows using fts5vocab('spelling_deltas', 'row');
+
+create trigger if not exists spelling_ai after insert on docs begin
+    insert into spelling_deltas(title, txt) values (new.title, uncompress(new.txt));
+end;
+
+create trigger if not exists spelling_ad after delete on docs begin
+    insert into spelling_deltas(title, txt) values (old.title, uncompress(old.txt));
+end;
+
+create trigger if not exists spelling_au after update of title, txt on docs begin
+    insert into spelling_deltas(title, txt) values (old.title, uncompress(old.txt));
+    insert into spelling_deltas(title, txt) values (new.title, uncompress(new.txt));
+end;
+
+-- Word lookup for incremental dictionary updates
+create index if not exists speling_vocab_word on speling_vocab(word);
@@ -0,0 +1,17 @@
+-- Copyright 2026 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+-- Per-space bm25 column weights, applied at query time
+alter table spaces add column titleWeight real not null default 5.0;
+alter table spaces add column textWeight real not null default 1.0;
@@ -0,0 +1,24 @@
+-- Copyright 2026 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+-- Keyword fields and tags, one row per document, field and value
+create table if not exists fields (
+    id integer not null,
+    field text not null,
+    value text not null,
+    primary key (id, field, value),
+    foreign key (id) references docs(id) on delete cascade
+) without rowid;
+
+create index if not exists fields_value on fields (field, value);
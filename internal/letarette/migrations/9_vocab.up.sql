@@ -0,0 +1,48 @@
+-- Copyright 2026 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+-- Persistent term statistics views of the full text index,
+-- usable from read-only connections
+create virtual table if not exists fts_rows using fts5vocab('fts', 'row');
+create virtual table if not exists fts_instances using fts5vocab('fts', 'instance');
+
+-- Unstemmed index of the document texts, providing the surface
+-- forms of indexed words for term completion and similar documents.
+-- The index has its own tokenizer pass, so every document write is
+-- tokenized once more. Building it for an existing corpus tokenizes
+-- all documents inside this migration, which takes about as long as
+-- a full "index rebuild".
+create virtual table if not exists fts_surface using fts5(
+    title, txt, content='cdocs', content_rowid='id',
+    tokenize='snowball unstemmed', detail='none'
+);
+
+create virtual table if not exists fts_surface_rows using fts5vocab('fts_surface', 'row');
+create virtual table if not exists fts_surface_instances using fts5vocab('fts_surface', 'instance');
+
+create trigger if not exists surface_ai after insert on docs begin
+    insert into fts_surface(rowid, title, txt) values (new.id, new.title, uncompress(new.txt));
+end;
+
+create trigger if not exists surface_ad after delete on docs begin
+    insert into fts_surface(fts_surface, rowid, title, txt) values ('delete', old.id, old.title, uncompress(old.txt));
+end;
+
+create trigger if not exists surface_au after update of title, txt on docs begin
+    insert into fts_surface(fts_surface, rowid, title, txt) values ('delete', old.id, old.title, uncompress(old.txt));
+    insert into fts_surface(rowid, title, txt) values (new.id, new.title, uncompress(new.txt));
+end;
+
+-- Indexes documents added before this migration
+insert into fts_surface(fts_surface) values ('rebuild');
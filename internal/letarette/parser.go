@@ -19,10 +19,21 @@ package letarette
 Search syntax:
 
 <phrase> ::= string | quotedstring
-<query> ::= [-] <phrase> [*]
-<query> ::= <query> <query>
+<field> ::= title: | text:
+<term> ::= [-] [<field>] <phrase> [*] [~]
+<term> ::= [-] [<field>] "(" <query> ")"
+<proximity> ::= <term> | <proximity> NEAR/n <term>
+<conjunction> ::= <proximity> | <conjunction> [AND] <proximity>
+<query> ::= <conjunction> | <query> OR <conjunction>
 
 Where the '-' prefix means "not" and the '*' denotes wildcard searches.
+The '~' suffix makes a single term fuzzy, also matching its closest
+neighbours in the spelling dictionary. Fuzzy terms cannot be joined by NEAR/n.
+The field prefix limits matching to the document title or text.
+Terms next to each other are implicitly AND:ed, and AND binds harder than OR.
+Terms joined by NEAR/n must occur within n tokens of each other.
+The AND, OR and NEAR operators are case sensitive, lower case versions are
+searched for as regular phrases.
 
 Examples:
 
@@ -30,14 +41,26 @@ animal -dog -cat
 
 horse* -"horse head"
 
-The output of the search parser is a list of including phrases and a list of
-excluding phrases. Both lists can contain wildcard expressions, which will lead
-to prefix searches.
+recieve~ invoice
+
+(invoice OR receipt) -draft
+
+title:budget text:"quarterly report"
+
+budget NEAR/5 report
+
+The output of the search parser is an expression tree, where each leaf is a
+phrase with exclusion, wildcard and fuzzy flags. Phrases can contain wildcard
+expressions, which will lead to prefix searches.
 
 The parser is very defensive and will always produce a valid query.
+Unbalanced parentheses are closed or skipped, and dangling operators are dropped.
 
-Searches will always be performed as "near" queries for all including phrases
-followed by a NOT list built from all excluding phrases.
+All including phrases of a conjunction are combined according to the
+requested match mode, by default as a "near" query, followed by a NOT list
+built from all excluding terms of the conjunction.
+A conjunction with only excluding terms cannot be searched for, and
+is dropped from the query.
 
 */
 
@@ -46,16 +69,26 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"text/scanner"
 	"unicode"
 )
 
-// Phrase represents one parsed query phrase, with flags
+// Searchable document fields, used as phrase field prefixes
+const (
+	FieldTitle = "title"
+	FieldText  = "text"
+)
+
+// Phrase represents one parsed query phrase, with flags.
+// When Field is set, the phrase only matches that document field.
 type Phrase struct {
 	Text     string
 	Wildcard bool
 	Exclude  bool
+	Field    string
+	Fuzzy    bool
 }
 
 func (p Phrase) String() string {
@@ -63,10 +96,16 @@ func (p Phrase) String() string {
 	if p.Exclude {
 		prefix = "-"
 	}
+	if p.Field != "" {
+		prefix += p.Field + ":"
+	}
 	suffix := ""
 	if p.Wildcard {
 		suffix = "*"
 	}
+	if p.Fuzzy {
+		suffix += "~"
+	}
 	phraseText := p.Text
 	if strings.Contains(phraseText, " ") && !strings.HasPrefix(phraseText, `"`) {
 		phraseText = fmt.Sprintf("%q", phraseText)
@@ -74,9 +113,155 @@ func (p Phrase) String() string {
 	return prefix + phraseText + suffix
 }
 
-// ParseQuery tokenizes a query string and returns a list
-// of parsed phrases with exclusion and wildcard flags.
-func ParseQuery(query string) []Phrase {
+// Operator is the type of an expression node
+type Operator int
+
+// Expression node types
+const (
+	// A single phrase, leaf node
+	OpPhrase Operator = iota
+	// All operands must match
+	OpAnd
+	// Any operand must match
+	OpOr
+	// All operands must match within Distance tokens
+	OpNear
+)
+
+// Expression is a node in a parsed query tree.
+// Leaf nodes hold one phrase, with the exclusion flag
+// kept in the phrase. Group nodes hold a list of operands
+// and their own exclusion flag.
+type Expression struct {
+	Operator Operator
+	Phrase   Phrase
+	Exclude  bool
+	Operands []Expression
+	Distance int
+}
+
+func (e Expression) isGroup() bool {
+	return e.Operator != OpPhrase
+}
+
+func (e Expression) excluded() bool {
+	if e.isGroup() {
+		return e.Exclude
+	}
+	return e.Phrase.Exclude
+}
+
+// IsEmpty returns true for expressions without any phrases
+func (e Expression) IsEmpty() bool {
+	return len(e.Phrases()) == 0
+}
+
+// Phrases returns all phrases of the expression tree in
+// depth first order.
+func (e Expression) Phrases() []Phrase {
+	var result []Phrase
+	e.walk(func(p *Phrase) {
+		result = append(result, *p)
+	})
+	return result
+}
+
+func (e *Expression) walk(visit func(*Phrase)) {
+	if !e.isGroup() {
+		visit(&e.Phrase)
+		return
+	}
+	for i := range e.Operands {
+		e.Operands[i].walk(visit)
+	}
+}
+
+// WithPhrases returns a copy of the expression tree, where the
+// phrase texts are replaced in the same order as returned by Phrases.
+func (e Expression) WithPhrases(phrases []Phrase) Expression {
+	clone := e.clone()
+	i := 0
+	clone.walk(func(p *Phrase) {
+		if i < len(phrases) {
+			p.Text = phrases[i].Text
+		}
+		i++
+	})
+	return clone
+}
+
+func (e Expression) clone() Expression {
+	clone := e
+	if e.isGroup() {
+		clone.Operands = make([]Expression, len(e.Operands))
+		for i, operand := range e.Operands {
+			clone.Operands[i] = operand.clone()
+		}
+	}
+	return clone
+}
+
+func (e Expression) String() string {
+	return e.format(true)
+}
+
+func (e Expression) format(root bool) string {
+	if !e.isGroup() {
+		return e.Phrase.String()
+	}
+	if len(e.Operands) == 1 && !e.Exclude {
+		return e.Operands[0].format(root)
+	}
+	separator := " "
+	switch e.Operator {
+	case OpOr:
+		separator = " OR "
+	case OpNear:
+		separator = fmt.Sprintf(" NEAR/%d ", e.Distance)
+	}
+	operands := make([]string, len(e.Operands))
+	for i, operand := range e.Operands {
+		operands[i] = operand.format(false)
+	}
+	formatted := strings.Join(operands, separator)
+	if !root || e.Exclude {
+		formatted = "(" + formatted + ")"
+	}
+	if e.Exclude {
+		formatted = "-" + formatted
+	}
+	return formatted
+}
+
+type queryToken struct {
+	kind rune
+	text string
+}
+
+const (
+	tokenOr    rune = -100
+	tokenAnd   rune = -101
+	tokenField rune = -102
+	tokenNear  rune = -103
+)
+
+var nearOperator = regexp.MustCompile(`^NEAR/(\d+)$`)
+
+var queryFields = []string{FieldTitle, FieldText}
+
+// splitFieldPrefix splits an identifier like "title:budget"
+// into a field name and the remaining text.
+func splitFieldPrefix(ident string) (string, string, bool) {
+	for _, field := range queryFields {
+		prefix := field + ":"
+		if len(ident) >= len(prefix) && strings.EqualFold(ident[:len(prefix)], prefix) {
+			return field, ident[len(prefix):], true
+		}
+	}
+	return "", ident, false
+}
+
+func tokenizeQuery(query string) []queryToken {
 	var s scanner.Scanner
 	s.Init(bytes.NewBufferString(query))
 	s.Mode = scanner.ScanIdents | scanner.ScanStrings
@@ -84,41 +269,199 @@ func ParseQuery(query string) []Phrase {
 		if r == '-' && i == 0 {
 			return false
 		}
-		if r == '*' || r == '"' || r == '\'' || r == '(' || r == ')' {
+		if r == '*' || r == '~' || r == '"' || r == '\'' || r == '(' || r == ')' {
 			return false
 		}
 		return unicode.IsGraphic(r) && !unicode.IsSpace(r)
 	}
 
-	var result []Phrase
-	excludeNext := false
-
+	var tokens []queryToken
 	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
 		text := s.TokenText()
-		switch tok {
-		case scanner.Ident:
-			//text = fmt.Sprintf("%q", text)
-			fallthrough
-		case scanner.String:
-			text = unquote(text)
-			result = append(result, Phrase{
-				Text:    text,
-				Exclude: excludeNext,
-			})
-			excludeNext = false
+		if tok == scanner.Ident {
+			switch text {
+			case "OR":
+				tok = tokenOr
+			case "AND":
+				tok = tokenAnd
+			}
+			if match := nearOperator.FindStringSubmatch(text); match != nil {
+				tok = tokenNear
+				text = match[1]
+			}
+			if field, rest, ok := splitFieldPrefix(text); ok {
+				tokens = append(tokens, queryToken{tokenField, field})
+				if rest == "" {
+					continue
+				}
+				text = rest
+			}
+		}
+		tokens = append(tokens, queryToken{tok, text})
+	}
+	return tokens
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() rune {
+	if p.pos >= len(p.tokens) {
+		return scanner.EOF
+	}
+	return p.tokens[p.pos].kind
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	p.pos++
+	return tok
+}
+
+func (p *queryParser) parseOr(depth int) Expression {
+	alternatives := []Expression{}
+	for {
+		conjunction := p.parseAnd(depth)
+		if len(conjunction.Operands) > 0 {
+			alternatives = append(alternatives, conjunction)
+		}
+		if p.peek() != tokenOr {
+			break
+		}
+		p.next()
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0]
+	}
+	return Expression{
+		Operator: OpOr,
+		Operands: alternatives,
+	}
+}
+
+func (p *queryParser) parseAnd(depth int) Expression {
+	conjunction := Expression{
+		Operator: OpAnd,
+		Operands: []Expression{},
+	}
+	for {
+		switch p.peek() {
+		case scanner.EOF, tokenOr:
+			return conjunction
+		case ')':
+			if depth > 0 {
+				return conjunction
+			}
+			// Skip unbalanced closing parenthesis
+			p.next()
+		case tokenAnd, tokenNear, '*', '~':
+			// Skip explicit AND, dangling NEAR, free wildcards and fuzzy operators
+			p.next()
+		default:
+			if term, ok := p.parseProximity(depth); ok {
+				conjunction.Operands = append(conjunction.Operands, term)
+			}
+		}
+	}
+}
+
+func (p *queryParser) parseProximity(depth int) (Expression, bool) {
+	term, ok := p.parseTerm(depth)
+	if !ok {
+		return term, false
+	}
+	for p.peek() == tokenNear {
+		distance, _ := strconv.Atoi(p.next().text)
+		next, ok := p.parseTerm(depth)
+		if !ok {
+			break
+		}
+		if term.Operator == OpNear && term.Distance == distance && !term.Exclude {
+			term.Operands = append(term.Operands, next)
+		} else {
+			term = Expression{
+				Operator: OpNear,
+				Operands: []Expression{term, next},
+				Distance: distance,
+			}
+		}
+	}
+	return term, true
+}
+
+func (p *queryParser) parseTerm(depth int) (Expression, bool) {
+	exclude := false
+	field := ""
+
+	for {
+		switch p.peek() {
 		case '-':
-			excludeNext = true
-		case '*':
-			l := len(result)
-			if l > 0 {
-				result[l-1].Wildcard = true
+			p.next()
+			exclude = true
+		case tokenField:
+			field = p.next().text
+		case scanner.Ident, scanner.String:
+			tok := p.next()
+			term := Expression{
+				Operator: OpPhrase,
+				Phrase: Phrase{
+					Text:    unquote(tok.text),
+					Exclude: exclude,
+					Field:   field,
+				},
+			}
+			for p.peek() == '*' {
+				p.next()
+				term.Phrase.Wildcard = true
+			}
+			for p.peek() == '~' {
+				p.next()
+				term.Phrase.Fuzzy = true
+			}
+			return term, true
+		case '(':
+			p.next()
+			group := p.parseOr(depth + 1)
+			if p.peek() == ')' {
+				p.next()
+			}
+			if field != "" {
+				group.walk(func(phrase *Phrase) {
+					if phrase.Field == "" {
+						phrase.Field = field
+					}
+				})
 			}
+			group.Exclude = exclude
+			return group, len(group.Operands) > 0
+		case scanner.EOF, tokenOr, tokenAnd, tokenNear, ')', '*', '~':
+			// Dangling exclusion
+			return Expression{}, false
 		default:
-			// skip
+			// Skip any other character
+			p.next()
 		}
 	}
+}
 
-	return result
+// ParseExpression tokenizes a query string and returns
+// the parsed expression tree. The returned tree is always
+// a group expression, which is empty for empty queries.
+func ParseExpression(query string) Expression {
+	parser := queryParser{
+		tokens: tokenizeQuery(query),
+	}
+	return parser.parseOr(0)
+}
+
+// ParseQuery tokenizes a query string and returns a list
+// of parsed phrases with exclusion and wildcard flags.
+// Grouping and operators are dropped, use ParseExpression
+// to get the full expression tree.
+func ParseQuery(query string) []Phrase {
+	return ParseExpression(query).Phrases()
 }
 
 var singleChars = regexp.MustCompile(`\PL\pL\PL`)
@@ -178,12 +521,18 @@ func CanonicalizePhraseList(phrases []Phrase) []Phrase {
 		} else if strDiff == 1 {
 			return false
 		}
+		if result[i].Field != result[j].Field {
+			return result[i].Field < result[j].Field
+		}
 		if result[i].Exclude != result[j].Exclude {
 			return result[j].Exclude
 		}
-		if result[i].Wildcard != result[j].Exclude {
+		if result[i].Wildcard != result[j].Wildcard {
 			return result[j].Wildcard
 		}
+		if result[i].Fuzzy != result[j].Fuzzy {
+			return result[j].Fuzzy
+		}
 		return false
 	})
 
@@ -197,3 +546,90 @@ func CanonicalizePhraseList(phrases []Phrase) []Phrase {
 	}
 	return unique
 }
+
+func reduceExpression(expr Expression) (Expression, bool) {
+	if !expr.isGroup() {
+		expr.Phrase.Text = reducePhrase(expr.Phrase.Text)
+		return expr, len(expr.Phrase.Text) > 0
+	}
+
+	reduced := expr
+	reduced.Operands = []Expression{}
+	for _, operand := range expr.Operands {
+		operand, keep := reduceExpression(operand)
+		if !keep {
+			continue
+		}
+		if operand.Operator == expr.Operator && operand.Distance == expr.Distance && !operand.Exclude {
+			reduced.Operands = append(reduced.Operands, operand.Operands...)
+		} else {
+			reduced.Operands = append(reduced.Operands, operand)
+		}
+	}
+
+	if len(reduced.Operands) == 1 {
+		single := reduced.Operands[0]
+		if !reduced.Exclude {
+			return single, true
+		}
+		if !single.excluded() {
+			single.Exclude = true
+			single.Phrase.Exclude = !single.isGroup()
+			return single, true
+		}
+	}
+
+	return reduced, len(reduced.Operands) > 0
+}
+
+// ReduceExpression removes one character phrases and empty groups
+// from an expression tree, and flattens redundant grouping.
+// The returned tree is always a group expression.
+func ReduceExpression(expr Expression) Expression {
+	reduced, keep := reduceExpression(expr)
+	if !keep {
+		return Expression{Operator: OpAnd}
+	}
+	if !reduced.isGroup() || reduced.Exclude {
+		return Expression{
+			Operator: OpAnd,
+			Operands: []Expression{reduced},
+		}
+	}
+	return reduced
+}
+
+// CanonicalizeExpression canonicalizes all phrase lists in an expression tree
+// using CanonicalizePhraseList, and sorts all sub groups.
+// Equivalent queries will have the same canonical string representation.
+func CanonicalizeExpression(expr Expression) Expression {
+	if !expr.isGroup() {
+		return expr
+	}
+
+	var phrases []Phrase
+	var groups []Expression
+	for _, operand := range expr.Operands {
+		if operand.isGroup() {
+			groups = append(groups, CanonicalizeExpression(operand))
+		} else {
+			phrases = append(phrases, operand.Phrase)
+		}
+	}
+
+	phrases = CanonicalizePhraseList(phrases)
+	sort.Slice(groups, func(i int, j int) bool {
+		return groups[i].String() < groups[j].String()
+	})
+
+	canonical := expr
+	canonical.Operands = make([]Expression, 0, len(phrases)+len(groups))
+	for _, phrase := range phrases {
+		canonical.Operands = append(canonical.Operands, Expression{
+			Operator: OpPhrase,
+			Phrase:   phrase,
+		})
+	}
+	canonical.Operands = append(canonical.Operands, groups...)
+	return canonical
+}
@@ -30,15 +30,15 @@ func TestParsePlainPhrases(t *testing.T) {
 	xt.Assert(len(r) == 3)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`cat`, false, false,
+		`cat`, false, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`dog`, false, false,
+		`dog`, false, false, "", false,
 	})
 
 	xt.Assert(r[2] == letarette.Phrase{
-		`banana`, false, false,
+		`banana`, false, false, "", false,
 	})
 }
 
@@ -49,19 +49,19 @@ func TestIncludeExcludePhrases(t *testing.T) {
 	xt.Assert(len(r) == 4)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`cat`, false, false,
+		`cat`, false, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`dog`, false, true,
+		`dog`, false, true, "", false,
 	})
 
 	xt.Assert(r[2] == letarette.Phrase{
-		`banana`, false, false,
+		`banana`, false, false, "", false,
 	})
 
 	xt.Assert(r[3] == letarette.Phrase{
-		`fishtank`, false, true,
+		`fishtank`, false, true, "", false,
 	})
 }
 
@@ -72,19 +72,19 @@ func TestWildcardPhrases(t *testing.T) {
 	xt.Assert(len(r) == 4)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`cat`, true, false,
+		`cat`, true, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`dog`, true, true,
+		`dog`, true, true, "", false,
 	})
 
 	xt.Assert(r[2] == letarette.Phrase{
-		`banana`, false, false,
+		`banana`, false, false, "", false,
 	})
 
 	xt.Assert(r[3] == letarette.Phrase{
-		`fishtank`, false, true,
+		`fishtank`, false, true, "", false,
 	})
 }
 
@@ -95,15 +95,15 @@ func TestEmbeddedAndFreeExcludes(t *testing.T) {
 	xt.Assert(len(r) == 3)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`cat-`, false, false,
+		`cat-`, false, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`cat-litter`, false, false,
+		`cat-litter`, false, false, "", false,
 	})
 
 	xt.Assert(r[2] == letarette.Phrase{
-		`dog`, false, true,
+		`dog`, false, true, "", false,
 	})
 }
 
@@ -114,19 +114,19 @@ func TestEmbeddedWildcard(t *testing.T) {
 	xt.Assert(len(r) == 4)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`cat`, true, false,
+		`cat`, true, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`cat`, true, false,
+		`cat`, true, false, "", false,
 	})
 
 	xt.Assert(r[2] == letarette.Phrase{
-		`litter`, false, false,
+		`litter`, false, false, "", false,
 	})
 
 	xt.Assert(r[3] == letarette.Phrase{
-		`*dog*`, false, false,
+		`*dog*`, false, false, "", false,
 	})
 }
 
@@ -137,11 +137,11 @@ func TestString(t *testing.T) {
 	xt.Assert(len(r) == 2)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`cat - * - dog`, false, false,
+		`cat - * - dog`, false, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`kawo\"nka`, true, false,
+		`kawo\"nka`, true, false, "", false,
 	})
 }
 
@@ -152,7 +152,7 @@ func TestBadString(t *testing.T) {
 	xt.Assert(len(r) == 1)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`cat *`, false, false,
+		`cat *`, false, false, "", false,
 	})
 }
 
@@ -163,15 +163,15 @@ func TestDoubleDoubleQuotedString(t *testing.T) {
 	xt.Assert(len(r) == 3)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		``, false, false,
+		``, false, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`dog`, false, false,
+		`dog`, false, false, "", false,
 	})
 
 	xt.Assert(r[2] == letarette.Phrase{
-		``, false, false,
+		``, false, false, "", false,
 	})
 }
 
@@ -182,11 +182,11 @@ func TestSingleQuoteExclusion(t *testing.T) {
 	xt.Assert(len(r) == 2)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`WinkelWolt`, false, false,
+		`WinkelWolt`, false, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`'Woff!`, false, false,
+		`'Woff!`, false, false, "", false,
 	})
 }
 
@@ -197,11 +197,11 @@ func TestParenthesisExclusion(t *testing.T) {
 	xt.Assert(len(r) == 2)
 
 	xt.Assert(r[0] == letarette.Phrase{
-		`WinkelWolt`, false, false,
+		`WinkelWolt`, false, false, "", false,
 	})
 
 	xt.Assert(r[1] == letarette.Phrase{
-		`()`, false, false,
+		`()`, false, false, "", false,
 	})
 }
 
@@ -233,6 +233,13 @@ func TestCanonicalizePhraseList(t *testing.T) {
 	listA = letarette.CanonicalizePhraseList(listA)
 	listB = letarette.CanonicalizePhraseList(listB)
 	xt.DeepEqual(listA, listB)
+
+	// Variants of the same text sort the same in any order
+	listA = letarette.ParseQuery(`title:report~ report* -report report~ title:report`)
+	listB = letarette.ParseQuery(`report~ title:report -report title:report~ report*`)
+	listA = letarette.CanonicalizePhraseList(listA)
+	listB = letarette.CanonicalizePhraseList(listB)
+	xt.DeepEqual(listA, listB)
 }
 
 func TestUnicodeCharacters(t *testing.T) {
@@ -242,3 +249,146 @@ func TestUnicodeCharacters(t *testing.T) {
 	xt.Assert(len(phrases) == 1)
 	xt.Assert(phrases[0].Text == `rökare`)
 }
+
+func TestParseOrGroups(t *testing.T) {
+	xt := xt.X(t)
+
+	e := letarette.ParseExpression(`(invoice OR receipt) -draft`)
+	xt.Equal(e.Operator, letarette.OpAnd)
+	xt.Assert(len(e.Operands) == 2)
+
+	group := e.Operands[0]
+	xt.Equal(group.Operator, letarette.OpOr)
+	xt.Assert(len(group.Operands) == 2)
+
+	xt.DeepEqual(e.Phrases(), []letarette.Phrase{
+		{`invoice`, false, false, "", false},
+		{`receipt`, false, false, "", false},
+		{`draft`, false, true, "", false},
+	})
+	xt.Equal(e.String(), `(invoice OR receipt) -draft`)
+}
+
+func TestAndBindsHarderThanOr(t *testing.T) {
+	xt := xt.X(t)
+
+	e := letarette.ParseExpression(`cat dog OR horse AND pony`)
+	xt.Equal(e.Operator, letarette.OpOr)
+	xt.Assert(len(e.Operands) == 2)
+	xt.Equal(e.String(), `(cat dog) OR (horse pony)`)
+}
+
+func TestLowerCaseOperatorsArePhrases(t *testing.T) {
+	xt := xt.X(t)
+
+	r := letarette.ParseQuery(`cat or dog and "OR"`)
+	xt.Assert(len(r) == 5)
+	xt.Equal(r[1].Text, `or`)
+	xt.Equal(r[4].Text, `OR`)
+}
+
+func TestExcludedGroup(t *testing.T) {
+	xt := xt.X(t)
+
+	e := letarette.ParseExpression(`animal -(dog OR cat*)`)
+	xt.Assert(len(e.Operands) == 2)
+	xt.Assert(e.Operands[1].Exclude)
+	xt.Equal(e.String(), `animal -(dog OR cat*)`)
+}
+
+func TestFieldScopedPhrases(t *testing.T) {
+	xt := xt.X(t)
+
+	phrases := letarette.ParseQuery(`title:budget* -TEXT:"quarterly report" text: plain`)
+	xt.DeepEqual(phrases, []letarette.Phrase{
+		{`budget`, true, false, letarette.FieldTitle, false},
+		{`quarterly report`, false, true, letarette.FieldText, false},
+		{`plain`, false, false, letarette.FieldText, false},
+	})
+
+	e := letarette.ParseExpression(`title:(cat OR text:dog) subtitle:x`)
+	xt.DeepEqual(e.Phrases(), []letarette.Phrase{
+		{`cat`, false, false, letarette.FieldTitle, false},
+		{`dog`, false, false, letarette.FieldText, false},
+		{`subtitle:x`, false, false, "", false},
+	})
+	xt.Equal(e.String(), `(title:cat OR text:dog) subtitle:x`)
+
+	e = letarette.ReduceExpression(letarette.ParseExpression(`cat title:`))
+	xt.Equal(e.String(), `cat`)
+}
+
+func TestNearOperator(t *testing.T) {
+	xt := xt.X(t)
+
+	e := letarette.ParseExpression(`cat NEAR/3 dog NEAR/3 horse pony`)
+	xt.Assert(len(e.Operands) == 2)
+	xt.Equal(e.Operands[0].Operator, letarette.OpNear)
+	xt.Equal(e.Operands[0].Distance, 3)
+	xt.Equal(len(e.Operands[0].Operands), 3)
+	xt.Equal(e.String(), `(cat NEAR/3 dog NEAR/3 horse) pony`)
+
+	e = letarette.ReduceExpression(letarette.ParseExpression(`cat NEAR/3 dog NEAR/5 horse`))
+	xt.Equal(e.String(), `(cat NEAR/3 dog) NEAR/5 horse`)
+
+	e = letarette.ReduceExpression(letarette.ParseExpression(`NEAR/2 cat NEAR/2 near/2 NEAR/xy`))
+	xt.Equal(e.String(), `(cat NEAR/2 near/2) NEAR/xy`)
+}
+
+func TestFuzzyOperator(t *testing.T) {
+	xt := xt.X(t)
+
+	phrases := letarette.ParseQuery(`recieve~ -title:invoce~ cat*~ ~ dog~~ "horse head"~`)
+	xt.DeepEqual(phrases, []letarette.Phrase{
+		{`recieve`, false, false, "", true},
+		{`invoce`, false, true, letarette.FieldTitle, true},
+		{`cat`, true, false, "", true},
+		{`dog`, false, false, "", true},
+		{`horse head`, false, false, "", true},
+	})
+
+	e := letarette.ParseExpression(`recieve~ OR -~ cat~dog`)
+	xt.Equal(e.String(), `recieve~ OR (cat~ dog)`)
+}
+
+func TestDefensiveExpressions(t *testing.T) {
+	xt := xt.X(t)
+
+	e := letarette.ParseExpression(`OR ((cat OR) AND - ) ) dog OR`)
+	e = letarette.ReduceExpression(e)
+	xt.Equal(e.String(), `cat dog`)
+
+	e = letarette.ReduceExpression(letarette.ParseExpression(`( OR ) - * AND`))
+	xt.Assert(e.IsEmpty())
+	xt.Equal(e.String(), ``)
+}
+
+func TestReduceExpression(t *testing.T) {
+	xt := xt.X(t)
+
+	e := letarette.ParseExpression(`(a OR (b c)) ((rökare)) -(x OR angle)`)
+	e = letarette.ReduceExpression(e)
+	xt.Equal(e.String(), `rökare -angle`)
+}
+
+func TestWithPhrases(t *testing.T) {
+	xt := xt.X(t)
+
+	e := letarette.ParseExpression(`(cat OR dgo*) -hrse`)
+	phrases := e.Phrases()
+	phrases[1].Text = "dog"
+	phrases[2].Text = "horse"
+	fixed := e.WithPhrases(phrases)
+	xt.Equal(fixed.String(), `(cat OR dog*) -horse`)
+	xt.Equal(e.String(), `(cat OR dgo*) -hrse`)
+}
+
+func TestCanonicalizeExpression(t *testing.T) {
+	xt := xt.X(t)
+
+	a := letarette.ParseExpression(`(Receipt OR invoice) -Draft (x y) Yabba`)
+	b := letarette.ParseExpression(`yabba -draft (y x) (INVOICE OR receipt OR invoice)`)
+	a = letarette.CanonicalizeExpression(letarette.ReduceExpression(a))
+	b = letarette.CanonicalizeExpression(letarette.ReduceExpression(b))
+	xt.Equal(a.String(), b.String())
+}
@@ -19,12 +19,13 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/nats-io/nats.go"
 
+	"github.com/erkkah/letarette/internal/auxiliary"
 	"github.com/erkkah/letarette/pkg/logger"
 	"github.com/erkkah/letarette/pkg/protocol"
 )
@@ -52,27 +53,105 @@ const minPagesize = 1
 const maxPagesize = 500
 
 func (s *searcher) spellSearch(
-	ctx context.Context, phrases []Phrase, query protocol.SearchRequest,
+	ctx context.Context, expression Expression, query protocol.SearchRequest, explain *explainer,
 ) (protocol.SearchResult, error) {
-	result, err := s.db.search(ctx, phrases, query.Spaces, query.PageLimit, query.PageOffset)
-	if err != nil || result.TotalHits != 0 {
+	start := time.Now()
+	result, err := s.db.search(ctx, expression, query)
+	explain.stage("search")
+	if err != nil || result.TotalHits > s.cfg.Spelling.FewHits {
 		return result, err
 	}
-	phrases, distance, changed, err := s.db.fixPhraseSpelling(ctx, phrases)
-	if err != nil || !changed {
+	// Correcting costs about as much as the search itself,
+	// and is skipped when there is no time left for it
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < time.Since(start) {
+		return result, nil
+	}
+	corrections, err := s.db.spellingCorrections(ctx, expression.Phrases(), s.cfg.Spelling.Corrections)
+	explain.stage("spelling")
+	if err != nil || len(corrections) == 0 {
+		if ctx.Err() != nil {
+			// Out of time, keep the uncorrected result
+			return result, nil
+		}
 		return result, err
 	}
-	terms := []string{}
-	for _, phrase := range phrases {
-		terms = append(terms, phrase.String())
+
+	// Only the closest correction is validated, by the corrected search
+	// itself when autocorrecting, otherwise by counting its hits
+	best := corrections[0]
+	respelt := expression.WithPhrases(best.phrases)
+	autocorrect := query.Autocorrect && result.TotalHits == 0
+	var corrected protocol.SearchResult
+	var hits int
+	if autocorrect {
+		corrected, err = s.db.search(ctx, respelt, query)
+		hits = corrected.TotalHits
+	} else {
+		hits, err = s.db.countHits(ctx, respelt, query)
+	}
+	explain.stage("corrections")
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, nil
+		}
+		return result, err
 	}
-	result.Respelt = strings.Join(terms, " ")
-	result.RespeltDistance = distance
-	if !query.Autocorrect {
+	if hits <= result.TotalHits {
 		return result, nil
 	}
-	result, err = s.db.search(ctx, phrases, query.Spaces, query.PageLimit, query.PageOffset)
-	return result, err
+	correction := protocol.Correction{
+		Query:    respelt.String(),
+		Distance: best.distance,
+		Hits:     hits,
+	}
+	explain.spelling(expression.Phrases(), best.phrases)
+
+	if autocorrect {
+		result = corrected
+		if explain != nil {
+			options, _ := matchOptionsFromRequest(query)
+			snippet, _ := snippetOptionsFromRequest(query)
+			explain.search(respelt, options, snippet.strategy)
+			explain.Autocorrected = true
+		}
+	}
+	result.Respelt = correction.Query
+	result.RespeltDistance = correction.Distance
+	result.Corrections = []protocol.Correction{correction}
+	return result, nil
+}
+
+// searchCacheKey builds a cache key from the canonical query
+// and all request parameters that affect the search result.
+// Results for relative update time bounds are cached like
+// any other result, and may be stale for the cache timeout.
+func searchCacheKey(expression Expression, query protocol.SearchRequest, options matchOptions) string {
+	canonical := CanonicalizeExpression(expression).String()
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	// The reference time only matters for relative bounds and boosts
+	now := ""
+	if query.UpdatedWithin != 0 || query.Sort == protocol.SortByRecency {
+		now = formatTime(query.Now)
+	}
+	// Filters are validated before the cache is used
+	filters, _ := parseFilters(query.Filters)
+	// Ranks change with the cluster wide term statistics
+	statsVersion := auxiliary.TermStatisticsVersion()
+	return fmt.Sprintf("%s|%v|%v/%d|%s-%s-%v|%v/%v/%v|%s|%s|%s|%v/%q|%q/%q|%v/%d/%d|%v|%d",
+		canonical, query.Fuzzy, options.mode, options.distance,
+		formatTime(query.UpdatedFrom), formatTime(query.UpdatedTo), query.UpdatedWithin,
+		query.Sort, query.RecencyWeight, query.RecencyHalfLife,
+		now, query.After, filters,
+		query.SpaceFacets, query.FieldFacets,
+		query.HighlightOpen, query.HighlightClose,
+		query.Snippet, query.SnippetTokens, query.SnippetFragments,
+		query.Exclude, statsVersion,
+	)
 }
 
 func (s *searcher) parseAndExecute(ctx context.Context, query protocol.SearchRequest) (protocol.SearchResponse, error) {
@@ -80,22 +159,36 @@ func (s *searcher) parseAndExecute(ctx context.Context, query protocol.SearchReq
 	var status protocol.SearchStatusCode
 
 	start := time.Now()
+	explain := newExplainer(query, s.cfg.Shard)
 	query.PageLimit = uint16(max(minPagesize, int(query.PageLimit)))
 	query.PageLimit = uint16(min(maxPagesize, int(query.PageLimit)))
-	phrases := ParseQuery(query.Query)
-	phrases = ReducePhraseList(phrases)
+	parsed := ParseExpression(query.Query)
+	expression := ReduceExpression(parsed)
+	explain.query(parsed, expression)
+	explain.stage("parse")
 
 	var result protocol.SearchResult
 
-	if len(query.Spaces) > 0 && len(phrases) > 0 {
-		cacheKey := fmt.Sprintf("%s", CanonicalizePhraseList(phrases))
+	options, err := matchOptionsFromRequest(query)
+	snippet, snippetErr := snippetOptionsFromRequest(query)
+	explain.search(expression, options, snippet.strategy)
+	if err == nil {
+		err = snippetErr
+	}
+	if err == nil {
+		_, err = parseFilters(query.Filters)
+	}
+
+	if err == nil && len(query.Spaces) > 0 && expressionToMatchString(expression, options) != "" {
+		cacheKey := searchCacheKey(expression, query, options)
 		var cached bool
 		result, cached = s.cache.Get(cacheKey, query.Spaces, query.PageLimit, query.PageOffset)
+		explain.stage("cache")
 
 		if cached {
 			status = protocol.SearchStatusCacheHit
 		} else {
-			result, err = s.spellSearch(ctx, phrases, query)
+			result, err = s.spellSearch(ctx, expression, query, explain)
 			if err == nil {
 				status = protocol.SearchStatusIndexHit
 				s.cache.Put(cacheKey, query.Spaces, query.PageLimit, query.PageOffset, result)
@@ -104,30 +197,113 @@ func (s *searcher) parseAndExecute(ctx context.Context, query protocol.SearchReq
 	}
 	duration := float32(time.Since(start)) / float32(time.Second)
 
+	if explainErr := explain.terms(ctx, s.db, expression, query.Fuzzy); explainErr != nil {
+		logger.Warning.Printf("Failed to explain query terms: %v", explainErr)
+	}
+	explain.stage("explain")
+
 	if err != nil {
-		var sqliteError sqlite3.Error
-		ok := errors.As(err, &sqliteError)
-
-		switch {
-		case ok && sqliteError.Code == sqlite3.ErrInterrupt:
-			status = protocol.SearchStatusTimeout
-		case errors.Is(err, context.DeadlineExceeded):
-			status = protocol.SearchStatusTimeout
-		default:
-			status = protocol.SearchStatusServerError
-		}
+		status = searchStatus(err)
 	} else if len(result.Hits) == 0 {
 		status = protocol.SearchStatusNoHit
 	}
 
 	response := protocol.SearchResponse{
-		Result:   result,
-		Status:   status,
-		Duration: duration,
+		Result:       result,
+		Status:       status,
+		Duration:     duration,
+		Explanations: explain.explanations(),
 	}
 	return response, err
 }
 
+// searchStatus maps search errors to status codes
+func searchStatus(err error) protocol.SearchStatusCode {
+	var sqliteError sqlite3.Error
+	ok := errors.As(err, &sqliteError)
+
+	switch {
+	case errors.Is(err, errInvalidQuery):
+		return protocol.SearchStatusQueryError
+	case ok && sqliteError.Code == sqlite3.ErrInterrupt:
+		return protocol.SearchStatusTimeout
+	case errors.Is(err, context.DeadlineExceeded):
+		return protocol.SearchStatusTimeout
+	default:
+		return protocol.SearchStatusServerError
+	}
+}
+
+func (s *searcher) suggest(ctx context.Context, request protocol.SuggestRequest) (protocol.SuggestResponse, error) {
+	start := time.Now()
+	suggestions, err := s.db.suggest(ctx, request)
+	response := protocol.SuggestResponse{
+		Suggestions: suggestions,
+		Duration:    float32(time.Since(start)) / float32(time.Second),
+		Status:      protocol.SearchStatusIndexHit,
+	}
+	if err != nil {
+		response.Status = searchStatus(err)
+	} else if len(suggestions) == 0 {
+		response.Status = protocol.SearchStatusNoHit
+	}
+	return response, err
+}
+
+func (s *searcher) similar(ctx context.Context, request protocol.SimilarRequest) (protocol.SimilarResponse, error) {
+	start := time.Now()
+	terms, err := s.db.similarTerms(ctx, request)
+	response := protocol.SimilarResponse{
+		Terms:    terms,
+		Duration: float32(time.Since(start)) / float32(time.Second),
+		Status:   protocol.SearchStatusIndexHit,
+	}
+	if err != nil {
+		response.Status = searchStatus(err)
+	} else if len(terms) == 0 {
+		response.Status = protocol.SearchStatusNoHit
+	}
+	return response, err
+}
+
+func (s *searcher) getDocument(ctx context.Context, request protocol.DocumentGetRequest) (protocol.DocumentGetResponse, error) {
+	start := time.Now()
+	doc, found, err := s.db.getDocument(ctx, request.Space, request.ID)
+	response := protocol.DocumentGetResponse{
+		Document: doc,
+		Duration: float32(time.Since(start)) / float32(time.Second),
+		Status:   protocol.SearchStatusIndexHit,
+	}
+	if err != nil {
+		response.Status = searchStatus(err)
+	} else if !found {
+		response.Status = protocol.SearchStatusNoHit
+	}
+	return response, err
+}
+
+// searchTimeout is the configured search timeout,
+// or the request timeout if shorter
+func searchTimeout(cfg Config, query protocol.SearchRequest) time.Duration {
+	if query.Timeout > 0 && query.Timeout < cfg.Search.Timeout {
+		return query.Timeout
+	}
+	return cfg.Search.Timeout
+}
+
+// setShardStatus reports the status of the given shard in a search response
+func setShardStatus(response *protocol.SearchResponse, shard string, err error) {
+	status := protocol.ShardStatus{
+		Shard:     shard,
+		Responded: true,
+		Status:    response.Status,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	response.Shards = []protocol.ShardStatus{status}
+}
+
 // StartSearcher creates and starts a searcher instance.
 func StartSearcher(nc *nats.Conn, db Database, cfg Config, cache *Cache) (Searcher, error) {
 	closer := make(chan bool)
@@ -145,45 +321,176 @@ func StartSearcher(nc *nats.Conn, db Database, cfg Config, cache *Cache) (Search
 		cache,
 	}
 
-	type searchWork struct {
-		req   protocol.SearchRequest
-		reply string
-	}
+	// Search work runs in the worker pool, and replies when done
+	type searchWork func()
 
 	// Worker pool = 4 * GOMAXPROCS
 	// I/O vs CPU, this needs measurements and tweaks.
 	numWorkers := 4 * runtime.GOMAXPROCS(-1)
 	// Queue size == 2 * workers
 	workChannel := make(chan searchWork, numWorkers*2)
+	// Closed when the searcher is closing. The work channel is never
+	// closed, since batches are queued from goroutines of their own.
+	closing := make(chan struct{})
 
 	for i := 0; i < numWorkers; i++ {
 		go func() {
-			for work := range workChannel {
-				// Handle query
-				ctx, cancel := context.WithTimeout(context.Background(), cfg.Search.Timeout)
-				response, err := self.parseAndExecute(ctx, work.req)
-				cancel()
-				if err != nil {
-					logger.Error.Printf("Failed to execute query: %v", err)
+			for {
+				select {
+				case work := <-workChannel:
+					work()
+				case <-closing:
+					return
 				}
-				// Reply
-				err = ec.Publish(work.reply, response)
-				if err != nil {
-					logger.Error.Printf("Failed to publish response: %v", err)
+			}
+		}()
+	}
+
+	// queue waits for room in the work queue,
+	// returns false if the searcher closed while waiting
+	queue := func(work searchWork) bool {
+		select {
+		case workChannel <- work:
+			return true
+		case <-closing:
+			return false
+		}
+	}
+
+	reply := func(reply string, response interface{}) {
+		err := ec.Publish(reply, response)
+		if err != nil {
+			logger.Error.Printf("Failed to publish response: %v", err)
+		}
+	}
+
+	// All requests are handled by one worker per shard. Requests concerning
+	// one document are sent to the shard owning it, on subjects ending
+	// with the shard.
+	var subscriptions []*nats.Subscription
+	unsubscribe := func() {
+		for _, subscription := range subscriptions {
+			_ = subscription.Unsubscribe()
+		}
+	}
+	subscribe := func(subject string, handler nats.Handler) error {
+		subscription, err := ec.QueueSubscribe(cfg.Nats.Topic+"."+subject, cfg.Shard, handler)
+		if err != nil {
+			unsubscribe()
+			return err
+		}
+		subscriptions = append(subscriptions, subscription)
+		return nil
+	}
+	shard := protocol.ShardString(int(cfg.ShardIndex), int(cfg.ShardgroupSize))
+
+	err = subscribe("q", func(sub, replyTo string, query *protocol.SearchRequest) {
+		queue(func() {
+			// Handle query
+			ctx, cancel := context.WithTimeout(context.Background(), searchTimeout(cfg, *query))
+			response, err := self.parseAndExecute(ctx, *query)
+			cancel()
+			if err != nil {
+				logger.Error.Printf("Failed to execute query: %v", err)
+			}
+			setShardStatus(&response, shard, err)
+			reply(replyTo, response)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Batched queries are queued to run in parallel in the worker pool,
+	// like single queries, and the batch response is sent when all
+	// queries are done. Queueing and waiting is done in a goroutine of
+	// its own, to never block the connection or the workers.
+	// Query deadlines are counted from the arrival of the batch.
+	err = subscribe("batch", func(sub, replyTo string, batch *protocol.SearchBatchRequest) {
+		start := time.Now()
+		responses := make([]protocol.SearchResponse, len(batch.Requests))
+		if len(batch.Requests) > protocol.MaxSearchBatchSize {
+			logger.Error.Printf("Search batch too large: %d requests", len(batch.Requests))
+			for i := range responses {
+				responses[i].Status = protocol.SearchStatusQueryError
+				setShardStatus(&responses[i], shard, nil)
+			}
+			reply(replyTo, protocol.SearchBatchResponse{Responses: responses})
+			return
+		}
+		go func() {
+			var wg sync.WaitGroup
+			for i := range batch.Requests {
+				i := i
+				deadline := start.Add(searchTimeout(cfg, batch.Requests[i]))
+				wg.Add(1)
+				queued := queue(func() {
+					defer wg.Done()
+					ctx, cancel := context.WithDeadline(context.Background(), deadline)
+					response, err := self.parseAndExecute(ctx, batch.Requests[i])
+					cancel()
+					if err != nil {
+						logger.Error.Printf("Failed to execute batched query: %v", err)
+					}
+					setShardStatus(&response, shard, err)
+					responses[i] = response
+				})
+				if !queued {
+					return
 				}
 			}
+			wg.Wait()
+			reply(replyTo, protocol.SearchBatchResponse{
+				Responses: responses,
+				Duration:  float32(time.Since(start)) / float32(time.Second),
+			})
 		}()
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	subscription, err := ec.QueueSubscribe(
-		cfg.Nats.Topic+".q", cfg.Shard,
-		func(sub, reply string, query *protocol.SearchRequest) {
-			workChannel <- searchWork{
-				req:   *query,
-				reply: reply,
+	err = subscribe("suggest", func(sub, replyTo string, request *protocol.SuggestRequest) {
+		queue(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Search.Timeout)
+			response, err := self.suggest(ctx, *request)
+			cancel()
+			if err != nil {
+				logger.Error.Printf("Failed to get suggestions: %v", err)
 			}
+			reply(replyTo, response)
 		})
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	err = subscribe("similar."+shard, func(sub, replyTo string, request *protocol.SimilarRequest) {
+		queue(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Search.Timeout)
+			response, err := self.similar(ctx, *request)
+			cancel()
+			if err != nil {
+				logger.Error.Printf("Failed to get similar terms: %v", err)
+			}
+			reply(replyTo, response)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = subscribe("document.get."+shard, func(sub, replyTo string, request *protocol.DocumentGetRequest) {
+		queue(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Search.Timeout)
+			response, err := self.getDocument(ctx, *request)
+			cancel()
+			if err != nil {
+				logger.Error.Printf("Failed to get document: %v", err)
+			}
+			reply(replyTo, response)
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -198,8 +505,8 @@ func StartSearcher(nc *nats.Conn, db Database, cfg Config, cache *Cache) (Search
 	go func() {
 		logger.Info.Printf("Searcher starting")
 		<-closer
-		close(workChannel)
-		_ = subscription.Unsubscribe()
+		unsubscribe()
+		close(closing)
 		logger.Info.Printf("Searcher exiting")
 		closer <- true
 	}()
@@ -15,26 +15,18 @@
 package letarette
 
 import (
-	"encoding/binary"
 	"fmt"
-	"hash/fnv"
 	"strconv"
 	"strings"
 
 	"github.com/erkkah/letarette/pkg/protocol"
 )
 
-var shardHasher = fnv.New32a()
-
 // ShardIndexFromDocumentID calculated a shard index based on a hash
 // of the document ID.
 // The hash algorithm is chosen for even distribution in a shard group.
 func ShardIndexFromDocumentID(docID protocol.DocumentID, shardGroupSize int) int {
-	shardHasher.Reset()
-	_, _ = shardHasher.Write([]byte(docID))
-	sum := shardHasher.Sum(nil)
-	intPart := binary.BigEndian.Uint32(sum)
-	return int(intPart % uint32(shardGroupSize))
+	return protocol.ShardIndexFromDocumentID(docID, shardGroupSize)
 }
 
 func parseShardString(shardGroup string) (group, size int, err error) {
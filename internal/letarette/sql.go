@@ -18,12 +18,57 @@ import (
 	"embed"
 	"fmt"
 	"strings"
+	"sync"
+	"text/template"
 )
 
+// Loaded and expanded queries, shared by concurrent searches
 var sqlCache = map[string]string{}
+var sqlLock sync.RWMutex
+var searchSQLCache = map[string]string{}
+var searchSQLLock sync.RWMutex
 
 func loadSearchQuery(strategy int) (string, error) {
-	return SQL(fmt.Sprintf("search_%d.sql", strategy))
+	return searchSQL(fmt.Sprintf("search_%d.sql", strategy))
+}
+
+// searchSQL loads a query built on the shared "matches" CTE of matches.sql
+func searchSQL(path string) (string, error) {
+	searchSQLLock.RLock()
+	expanded, found := searchSQLCache[path]
+	searchSQLLock.RUnlock()
+	if found {
+		return expanded, nil
+	}
+
+	matches, err := SQL("matches.sql")
+	if err != nil {
+		return "", err
+	}
+	query, err := SQL(path)
+	if err != nil {
+		return "", err
+	}
+
+	templates, err := template.New("matches.sql").Parse(matches)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse matches template: %w", err)
+	}
+	templates, err = templates.New(path).Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var builder strings.Builder
+	err = templates.Execute(&builder, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand %s: %w", path, err)
+	}
+	result := builder.String()
+	searchSQLLock.Lock()
+	searchSQLCache[path] = result
+	searchSQLLock.Unlock()
+	return result, nil
 }
 
 //go:embed sql
@@ -31,7 +76,10 @@ var sqlFS embed.FS
 
 // SQL loads sql code from resources and strips away comments
 func SQL(path string) (string, error) {
-	if loaded, found := sqlCache[path]; found {
+	sqlLock.RLock()
+	loaded, found := sqlCache[path]
+	sqlLock.RUnlock()
+	if found {
 		return loaded, nil
 	}
 
@@ -53,6 +101,8 @@ func SQL(path string) (string, error) {
 		uncommented = append(uncommented, trimmed)
 	}
 	result := strings.Join(uncommented, "\n")
+	sqlLock.Lock()
 	sqlCache[path] = result
+	sqlLock.Unlock()
 	return result, nil
 }
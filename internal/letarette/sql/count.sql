@@ -0,0 +1,31 @@
+-- Copyright 2026 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+-- Counts the same capped set of matches as the search strategies,
+-- without sorting or building snippets.
+
+{{define "matchColumns"}}
+{{- end}}
+
+with
+{{template "matches"}}
+select
+    count(*)
+from
+    matches
+    join docs on docs.id = matches.rowid
+    join spaces using(spaceID)
+where
+    space in (:spaces)
+    and docs.alive
@@ -0,0 +1,51 @@
+-- Copyright 2026 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+-- Facet counts over the same capped set of matches as the search strategies,
+-- ignoring any cursor position.
+
+{{define "matchColumns"}}
+{{- end}}
+
+with
+{{template "matches"}},
+hits as (
+    select
+        docs.id, space
+    from
+        matches
+        join docs on docs.id = matches.rowid
+        join spaces using(spaceID)
+    where
+        space in (:spaces)
+        and docs.alive
+)
+select
+    1 as isSpace, '' as field, space as value, count(*) as count
+from
+    hits
+where
+    :spaceFacets
+group by
+    space
+union all
+select
+    0 as isSpace, fields.field, fields.value, count(*) as count
+from
+    hits
+    join fields on fields.id = hits.id
+where
+    fields.field in (select value from json_each(:fieldFacets))
+group by
+    fields.field, fields.value
@@ -0,0 +1,67 @@
+-- Copyright 2019 Erik Agsjö
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+-- Shared "matches" CTE of the search strategies and facet counts.
+-- Strategy specific columns, computed from the fts match, are added
+-- by defining the "matchColumns" template.
+
+{{define "matches" -}}
+matches as (
+    select
+        fts.rowid as rowid,
+        {{- template "matchColumns"}}
+        -- bm25 with cluster wide term statistics when available, the space
+        -- column weights, the static document boost
+        -- and a hyperbolic recency boost, halved at the given document age
+        globalbm25(fts, :statsDocuments, :statsTokens, spaces.titleWeight, spaces.textWeight) - docs.boost - :recencyWeight * :recencyHalfLife /
+            (:recencyHalfLife + max(:now - docs.updatedNanos, 0)) as r,
+        -- hits only matching fuzzy expansions, sorted after exact hits by rank
+        :fuzzy and fts.rowid not in (select rowid from fts where fts match :exactMatch) as fuzzy
+    from
+        fts
+        -- cross join forces fts to drive the query
+        cross join docs on docs.id = fts.rowid
+        join spaces on spaces.spaceID = docs.spaceID
+    where
+        fts match :match
+        and docs.updatedNanos >= :updatedFrom
+        and docs.updatedNanos < :updatedTo
+        -- field filters, hits must not violate any filter
+        and not exists (
+            select 1 from json_each(:filters) as filter
+            where exists (
+                select 1 from fields
+                where
+                    fields.id = docs.id
+                    and fields.field = json_extract(filter.value, '$.field')
+                    and fields.value in (select value from json_each(filter.value, '$.values'))
+            ) = json_extract(filter.value, '$.exclude')
+        )
+        -- excluded documents
+        and not exists (
+            select 1 from json_each(:exclude) as excluded
+            where
+                json_extract(excluded.value, '$.Space') = spaces.space
+                and json_extract(excluded.value, '$.ID') = docs.docID
+        )
+        -- only hits after the cursor, using the same ordering as the strategies
+        and (not :after or (
+            case when :sortByDate then -docs.updatedNanos else fuzzy end,
+            r,
+            spaces.space,
+            docs.docID
+        ) > (:afterKey, :afterRank, :afterSpace, :afterID))
+    limit :cap
+)
+{{- end}}
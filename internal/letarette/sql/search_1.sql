@@ -14,49 +14,56 @@
 
 -- Subquery - based strategy, trying to eliminate rows early.
 
-with
-matches as (
-    select
-        rowid,
+{{define "matchColumns"}}
         firstmatch(fts, 0) as matchColumn,
-        firstmatch(fts, 1) as matchOffset,
-        tokens(fts, firstmatch(fts, 0)) as numTokens,
-        rank as r
-    from
-        fts
-    where
-        fts match :match
-    limit :cap
-),
+        -- all matched token offsets are only needed for highlighting
+        -- and placing several fragments, otherwise the first match will do
+        case when :highlight or :snippetFragments > 1
+            then matchtokens(fts, firstmatch(fts, 0))
+            else firstmatch(fts, 1)
+        end as snippetMatches,
+        case when :highlight then matchtokens(fts, 0) end as titleMatches,
+{{- end}}
+
+with
+{{template "matches"}},
 stats as (
-    select count(*) as cnt from matches
+    -- counting is skipped on cursor pages after the first
+    select count(*) as cnt from matches where :count
 )
 select
-    space, r as rank, cnt as total, joined.docID as id,
-    substr("…", 1, (matchOffset > 1)) ||
+    space, r as rank, fuzzy, cnt as total, joined.docID as id, docs.updatedNanos,
     replace(
-        gettokens(fts,
+        fragments(fts,
             case matchColumn
                 when 0 then docs.title
                 when 1 then uncompress(docs.txt)
             end,
-            max(matchOffset-1, 0), 10),
+            snippetMatches, :snippetTokens, :snippetFragments, :highlightOpen, :highlightClose),
         X'0A', " "
-    )
-    || substr("…", 1, (numTokens > 10))
-    as snippet
+    ) as snippet,
+    case when :highlight then
+        replace(
+            gettokens(fts, docs.title, 0, -1, titleMatches, :highlightOpen, :highlightClose),
+            X'0A', " "
+        )
+    else "" end as title
 from (
     select
-        space, matchColumn, matchOffset, numTokens, r, stats.cnt, docs.docID, docs.id
+        space, matchColumn, snippetMatches, titleMatches,
+        r, fuzzy, stats.cnt, docs.docID, docs.id
     from
         matches
         left join docs on docs.id = matches.rowid
         cross join stats
         join spaces using(spaceID)
     where
-        space in (?)
+        space in (:spaces)
         and docs.alive
-    order by r asc
+    order by
+        -- tie breakers keep the order stable across shards
+        case when :sortByDate then -docs.updatedNanos else fuzzy end,
+        r, space, docs.docID
     limit :limit
     offset :offset
 ) joined
@@ -13,37 +13,56 @@
 -- limitations under the License.
 
 -- Subquery - based strategy, trying to eliminate rows early.
--- Title is used as matching snippet, further eliminating query-time work.
+-- Title, or nothing, is used as snippet, further eliminating query-time work.
+
+{{define "matchColumns"}}
+        -- matched title token offsets, only needed for highlighting
+        case when :highlight then matchtokens(fts, 0) end as titleMatches,
+{{- end}}
 
 with
-matches as (
-    select
-        rowid,
-        rank as r
-    from
-        fts
-    where
-        fts match :match
-    limit :cap
-),
+{{template "matches"}},
 stats as (
-    select count(*) as cnt from matches
+    -- counting is skipped on cursor pages after the first
+    select count(*) as cnt from matches where :count
 )
 select
     space,
     r as rank,
+    fuzzy,
     stats.cnt as total,
     docs.docID as id,
-    docs.title as snippet
+    docs.updatedNanos,
+    -- the highlighted title is also used as snippet when highlighting
+    case
+        when not :snippetTitle then ""
+        when :highlight then
+            replace(
+                gettokens(fts, docs.title, 0, -1, titleMatches, :highlightOpen, :highlightClose),
+                X'0A', " "
+            )
+        else docs.title
+    end as snippet,
+    case when :highlight then
+        replace(
+            gettokens(fts, docs.title, 0, -1, titleMatches, :highlightOpen, :highlightClose),
+            X'0A', " "
+        )
+    else "" end as title
 from
     matches
     left join docs on docs.id = matches.rowid
+    -- Join in fts to get an fts handle to run "gettokens" on
+    left join fts on fts.rowid = (select id from docs limit 1)
     cross join stats
     join spaces using(spaceID)
 where
-    space in (?)
+    space in (:spaces)
     and docs.alive
-order by r asc
+order by
+    -- tie breakers keep the order stable across shards
+    case when :sortByDate then -docs.updatedNanos else fuzzy end,
+    r, space, docs.docID
 limit :limit
 offset :offset
 
@@ -0,0 +1,250 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/erkkah/letarette/internal/auxiliary"
+	"github.com/erkkah/letarette/pkg/logger"
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+// TermStatsSharer broadcasts the term statistics of the local shard, and
+// collects the statistics of all shards in the shard group. The summed
+// statistics are used for ranking hits, making ranks comparable across shards.
+type TermStatsSharer interface {
+	Close()
+}
+
+// Max number of terms per statistics message
+const termStatsChunkSize = 10000
+
+// Statistics not updated for this many intervals are dropped
+const termStatsMaxAge = 3
+
+// Max number of received chunks waiting to be added.
+// Chunks arriving when the queue is full are dropped, leaving
+// the update of the sending shard incomplete until its next update.
+const termStatsChunkQueueSize = 64
+
+// StartTermStatsSharer creates a TermStatsSharer and starts sharing statistics
+func StartTermStatsSharer(nc *nats.Conn, db Database, cfg Config) (TermStatsSharer, error) {
+	ec, err := nats.NewEncodedConn(nc, nats.JSON_ENCODER)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	self := &termStatsSharer{
+		ctx:     ctx,
+		close:   cancel,
+		cfg:     cfg,
+		conn:    ec,
+		db:      db.(*database),
+		shard:   protocol.ShardString(int(cfg.ShardIndex), int(cfg.ShardgroupSize)),
+		chunks:  make(chan protocol.TermStatistics, termStatsChunkQueueSize),
+		pending: map[string]*shardTermStats{},
+		shards:  map[string]*shardTermStats{},
+	}
+
+	sub, err := ec.Subscribe(cfg.Nats.Topic+".termstats", func(stats *protocol.TermStatistics) {
+		_, size, err := parseShardString(stats.Shard)
+		if err != nil || size != int(cfg.ShardgroupSize) || stats.Shard == self.shard {
+			return
+		}
+		// Never block the connection dispatcher
+		select {
+		case self.chunks <- *stats:
+		default:
+			logger.Warning.Printf("Dropping term statistics chunk from shard %v", stats.Shard)
+		}
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		share := time.After(time.Second * 5)
+		for {
+			select {
+			case chunk := <-self.chunks:
+				if self.addChunk(chunk) {
+					self.update()
+				}
+			case <-share:
+				self.share()
+				self.update()
+				share = time.After(cfg.Search.Stats.Interval)
+			case <-self.ctx.Done():
+				_ = sub.Unsubscribe()
+				auxiliary.SetTermStatistics(nil)
+				return
+			}
+		}
+	}()
+
+	return self, nil
+}
+
+type shardTermStats struct {
+	// Update time, as set by the sending shard
+	updated time.Time
+	// Local time when the update was complete
+	completed time.Time
+	chunks    int
+	stats     auxiliary.TermStatistics
+}
+
+type termStatsSharer struct {
+	ctx     context.Context
+	close   context.CancelFunc
+	cfg     Config
+	conn    *nats.EncodedConn
+	db      *database
+	shard   string
+	chunks  chan protocol.TermStatistics
+	pending map[string]*shardTermStats
+	shards  map[string]*shardTermStats
+}
+
+func (s *termStatsSharer) Close() {
+	s.close()
+}
+
+// share reads the local statistics and broadcasts them in chunks
+func (s *termStatsSharer) share() {
+	stats, err := s.db.getTermStatistics(s.ctx, s.cfg.Search.Stats.Terms)
+	if err != nil {
+		logger.Error.Printf("Failed to read term statistics: %v", err)
+		return
+	}
+	updated := time.Now()
+	s.shards[s.shard] = &shardTermStats{updated: updated, completed: updated, stats: stats}
+
+	var chunks []map[string]int64
+	for term, documents := range stats.Terms {
+		if len(chunks) == 0 || len(chunks[len(chunks)-1]) == termStatsChunkSize {
+			chunks = append(chunks, map[string]int64{})
+		}
+		chunks[len(chunks)-1][term] = documents
+	}
+	if len(chunks) == 0 {
+		chunks = append(chunks, map[string]int64{})
+	}
+
+	for i, terms := range chunks {
+		err = s.conn.Publish(s.cfg.Nats.Topic+".termstats", &protocol.TermStatistics{
+			Shard:     s.shard,
+			Updated:   updated,
+			Chunk:     i,
+			NumChunks: len(chunks),
+			Documents: stats.Documents,
+			Tokens:    stats.Tokens,
+			Terms:     terms,
+		})
+		if err != nil {
+			logger.Error.Printf("Failed to publish term statistics: %v", err)
+			return
+		}
+	}
+}
+
+// addChunk adds a received chunk to the pending update of its shard.
+// Returns true when the update is complete, replacing the shard statistics.
+func (s *termStatsSharer) addChunk(chunk protocol.TermStatistics) bool {
+	pending := s.pending[chunk.Shard]
+	if pending == nil || !pending.updated.Equal(chunk.Updated) {
+		if pending != nil && pending.updated.After(chunk.Updated) {
+			return false
+		}
+		pending = &shardTermStats{
+			updated: chunk.Updated,
+			stats: auxiliary.TermStatistics{
+				Documents: chunk.Documents,
+				Tokens:    chunk.Tokens,
+				Terms:     map[string]int64{},
+			},
+		}
+		s.pending[chunk.Shard] = pending
+	}
+
+	for term, documents := range chunk.Terms {
+		pending.stats.Terms[term] = documents
+	}
+	pending.chunks++
+	if pending.chunks < chunk.NumChunks {
+		return false
+	}
+	pending.completed = time.Now()
+	delete(s.pending, chunk.Shard)
+	s.shards[chunk.Shard] = pending
+	return true
+}
+
+// update sums the statistics of all shards into cluster wide statistics.
+// Until statistics are known for all shards, local statistics are used.
+// Shards only share their most common terms, so only terms shared by
+// all shards get cluster wide counts, other terms are ranked using local
+// statistics. Unchanged statistics are kept, keeping cached searches.
+func (s *termStatsSharer) update() {
+	horizon := time.Now().Add(-termStatsMaxAge * s.cfg.Search.Stats.Interval)
+	for shard, stats := range s.shards {
+		if stats.completed.Before(horizon) {
+			delete(s.shards, shard)
+		}
+	}
+	if len(s.shards) < int(s.cfg.ShardgroupSize) {
+		auxiliary.SetTermStatistics(nil)
+		return
+	}
+
+	global := &auxiliary.TermStatistics{
+		Terms: map[string]int64{},
+	}
+	var first *shardTermStats
+	for _, shard := range s.shards {
+		global.Documents += shard.stats.Documents
+		global.Tokens += shard.stats.Tokens
+		first = shard
+	}
+	for term := range first.stats.Terms {
+		var documents int64
+		shared := true
+		for _, shard := range s.shards {
+			count, found := shard.stats.Terms[term]
+			if !found {
+				shared = false
+				break
+			}
+			documents += count
+		}
+		if shared {
+			global.Terms[term] = documents
+		}
+	}
+
+	current := auxiliary.GetTermStatistics()
+	if current != nil && current.Documents == global.Documents &&
+		current.Tokens == global.Tokens && maps.Equal(current.Terms, global.Terms) {
+		return
+	}
+	auxiliary.SetTermStatistics(global)
+}
@@ -0,0 +1,86 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package letarette
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erkkah/letarette/internal/auxiliary"
+	"github.com/erkkah/letarette/pkg/protocol"
+	"github.com/erkkah/letarette/pkg/xt"
+)
+
+func TestTermStatsSharer_Chunks(t *testing.T) {
+	xt := xt.X(t)
+	defer auxiliary.SetTermStatistics(nil)
+
+	var cfg Config
+	cfg.ShardgroupSize = 2
+	cfg.Search.Stats.Interval = time.Minute
+	sharer := &termStatsSharer{
+		cfg:     cfg,
+		shard:   "1/2",
+		pending: map[string]*shardTermStats{},
+		shards: map[string]*shardTermStats{
+			"1/2": {
+				completed: time.Now(),
+				stats: auxiliary.TermStatistics{
+					Documents: 10, Tokens: 100, Terms: map[string]int64{"cat": 2, "dog": 1},
+				},
+			},
+		},
+	}
+
+	updated := time.Now()
+	chunk := func(i int, terms map[string]int64) protocol.TermStatistics {
+		return protocol.TermStatistics{
+			Shard: "2/2", Updated: updated, Chunk: i, NumChunks: 2,
+			Documents: 5, Tokens: 20, Terms: terms,
+		}
+	}
+
+	xt.False(sharer.addChunk(chunk(0, map[string]int64{"cat": 3})))
+	// Chunks of older updates are ignored
+	stale := chunk(1, map[string]int64{"horse": 1})
+	stale.Updated = updated.Add(-time.Second)
+	xt.False(sharer.addChunk(stale))
+	xt.True(sharer.addChunk(chunk(1, map[string]int64{"pony": 4})))
+
+	version := auxiliary.TermStatisticsVersion()
+	sharer.update()
+	xt.Assert(auxiliary.TermStatisticsVersion() > version)
+	stats := auxiliary.GetTermStatistics()
+	xt.NotNil(stats)
+	xt.Equal(stats.Documents, int64(15))
+	xt.Equal(stats.Tokens, int64(120))
+	// Terms not shared by all shards are left to local statistics
+	xt.DeepEqual(stats.Terms, map[string]int64{"cat": 5})
+
+	// Unchanged statistics keep the version
+	version = auxiliary.TermStatisticsVersion()
+	sharer.update()
+	xt.Equal(auxiliary.TermStatisticsVersion(), version)
+
+	// Stale shards are dropped, falling back to local statistics
+	sharer.shards["2/2"].completed = time.Now().Add(-time.Hour)
+	sharer.update()
+	xt.Assert(auxiliary.GetTermStatistics() == nil)
+
+	// Staying without cluster statistics keeps the version
+	version = auxiliary.TermStatisticsVersion()
+	sharer.update()
+	xt.Equal(auxiliary.TermStatisticsVersion(), version)
+}
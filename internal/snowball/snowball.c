@@ -38,6 +38,8 @@ struct StemmerModuleData {
 
 struct StemmerInstance {
     struct StemmerModuleData* module;
+    // Set by the "unstemmed" tokenizer argument, passing tokens through as is
+    int unstemmed;
 	fts5_tokenizer parentModule;
 	Fts5Tokenizer *parentInstance;
     sqlite3_stmt *stopwordStatement;
@@ -64,6 +66,15 @@ static int ftsSnowballCreate(
     }
 
     instance->module = modData;
+    instance->unstemmed = 0;
+    for (int i = 0; i < nArg; i++) {
+        if (sqlite3_stricmp(azArg[i], "unstemmed") == 0) {
+            instance->unstemmed = 1;
+        } else {
+            sqlite3_free(instance);
+            return SQLITE_ERROR;
+        }
+    }
 
     const char * const parentStemmer = "unicode61";
     void* parentUserData = 0;
@@ -199,7 +210,7 @@ static int ftsSnowballCallback(
     }
 
     // Only call snowball for tokens within the set interval
-    if (nToken > MAX_TOKEN_LEN || nToken < MIN_TOKEN_LEN) {
+    if (ctx->instance->unstemmed || nToken > MAX_TOKEN_LEN || nToken < MIN_TOKEN_LEN) {
         int rc = ctx->xToken(ctx->callerContext, tflags, pToken, nToken, iStart, iEnd);
         if (rc != SQLITE_OK) {
             return rc;
@@ -15,197 +15,339 @@
 package client
 
 import (
-	"fmt"
-	"sort"
-	"sync/atomic"
+	"context"
+	"strings"
 	"time"
 
 	"github.com/erkkah/letarette/pkg/protocol"
 )
 
-// SearchAgent is a letarette cluster searcher
+// SearchAgent is a letarette cluster searcher.
+//
+// The Context variants of the request methods stop waiting for responses when
+// the context is done. The remaining time until the context deadline is
+// passed on to the workers in search requests.
 type SearchAgent interface {
 	Close()
-	Search(q string, spaces []string, pageLimit int, pageOffset int) (protocol.SearchResponse, error)
+	Search(
+		q string, spaces []string, pageLimit int, pageOffset int, options ...SearchOption,
+	) (protocol.SearchResponse, error)
+	SearchContext(
+		ctx context.Context, q string, spaces []string, pageLimit int, pageOffset int, options ...SearchOption,
+	) (protocol.SearchResponse, error)
+	SearchSimilar(
+		space string, id protocol.DocumentID, spaces []string, pageLimit int, pageOffset int, options ...SearchOption,
+	) (protocol.SearchResponse, error)
+	SearchSimilarContext(
+		ctx context.Context, space string, id protocol.DocumentID, spaces []string,
+		pageLimit int, pageOffset int, options ...SearchOption,
+	) (protocol.SearchResponse, error)
+	GetDocument(space string, id protocol.DocumentID) (protocol.DocumentGetResponse, error)
+	GetDocumentContext(ctx context.Context, space string, id protocol.DocumentID) (protocol.DocumentGetResponse, error)
+	// SearchBatch performs several independent searches in one roundtrip
+	// to each shard, returning one response per request, in request order.
+	SearchBatch(requests []protocol.SearchRequest) ([]protocol.SearchResponse, error)
+	SearchBatchContext(ctx context.Context, requests []protocol.SearchRequest) ([]protocol.SearchResponse, error)
 }
 
-// WithShardgroupSize forces shard group size instead of using discovery
-func WithShardgroupSize(groupSize int32) Option {
-	return func(st *state) {
-		sa := st.local.(*searchAgent)
-		sa.volatileNumShards = groupSize
+// SearchOption sets per-request search parameters. See related functions below.
+type SearchOption func(*protocol.SearchRequest)
+
+// WithMatchMode sets how query terms are combined
+func WithMatchMode(mode protocol.MatchMode) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.MatchMode = mode
 	}
 }
 
-// WithTimeout sets search request timeout
-func WithTimeout(timeout time.Duration) Option {
-	return func(st *state) {
-		sa := st.local.(*searchAgent)
-		sa.timeout = timeout
+// WithProximity requires query terms to occur within the given
+// number of tokens from each other
+func WithProximity(distance uint16) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.MatchMode = protocol.MatchProximity
+		req.MatchDistance = distance
 	}
 }
 
-// NewSearchAgent - SearchAgent constructor
-func NewSearchAgent(URLs []string, options ...Option) (SearchAgent, error) {
-	agent := &searchAgent{
-		state: state{
-			topic:   "leta",
-			onError: func(error) {},
-		},
-		volatileNumShards: 0,
-		timeout:           time.Second * 2,
+// WithFuzzy matches all query terms fuzzily, as if suffixed
+// by the "~" operator
+func WithFuzzy() SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.Fuzzy = true
 	}
+}
 
-	agent.local = agent
-	agent.apply(options)
+// WithCursor continues a search after the position of a
+// cursor returned in a previous SearchResult, or starts a
+// walk over all hits using protocol.CursorStart.
+// The page offset is ignored for cursor searches.
+func WithCursor(cursor string) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.After = cursor
+	}
+}
 
-	ec, err := connect(URLs, agent.state)
-	if err != nil {
-		return nil, err
+// WithExplain requests search diagnostics from all shards,
+// returned in SearchResponse.Explanations
+func WithExplain() SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.Explain = true
 	}
+}
 
-	agent.conn = ec
-
-	if agent.volatileNumShards == 0 {
-		agent.monitor, err = NewMonitor(
-			URLs,
-			func(status protocol.IndexStatus) {
-				if status.Status == protocol.IndexStatusInSync || status.Status == protocol.IndexStatusSyncing {
-					atomic.SwapInt32(&agent.volatileNumShards, int32(status.ShardgroupSize))
-				}
-			},
-			WithTopic(agent.topic),
-			WithSeedFile(agent.seedFile),
-			WithRootCAs(agent.rootCAs...),
-		)
-		if err != nil {
-			return nil, err
-		}
+// WithFilters restricts hits to documents matching all of the
+// given field filter expressions, like "tag=finance" or "author in (a, b)"
+func WithFilters(filters ...string) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.Filters = append(req.Filters, filters...)
 	}
+}
 
-	return agent, nil
+// WithSpaceFacets requests hit counts per space
+func WithSpaceFacets() SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.SpaceFacets = true
+	}
 }
 
-type searchAgent struct {
-	state
-	volatileNumShards int32
-	monitor           Monitor
-	timeout           time.Duration
+// WithFieldFacets requests hit counts per value of the given fields
+func WithFieldFacets(fields ...string) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.FieldFacets = append(req.FieldFacets, fields...)
+	}
 }
 
-func (agent *searchAgent) Close() {
-	if agent.monitor != nil {
-		agent.monitor.Close()
+// WithHighlight inserts the given markers around matched
+// tokens in hit snippets, and sets highlighted hit titles
+func WithHighlight(open string, close string) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.HighlightOpen = open
+		req.HighlightClose = close
 	}
+}
 
-	agent.conn.Close()
+// WithSnippet sets what is returned as hit snippets
+func WithSnippet(mode protocol.SnippetMode) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.Snippet = mode
+	}
 }
 
-func (agent *searchAgent) getNumShards() (int32, error) {
-	start := time.Now()
-	for {
-		numShards := atomic.LoadInt32(&agent.volatileNumShards)
-		if numShards == 0 {
-			if time.Now().After(start.Add(time.Second * 5)) {
-				return 0, fmt.Errorf("timeout waiting for cluster")
-			}
-			time.Sleep(time.Millisecond * 100)
-		} else {
-			return numShards, nil
-		}
+// WithSnippetSize sets the number of tokens per text snippet
+// fragment, and the max number of fragments
+func WithSnippetSize(tokens uint16, fragments uint8) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.SnippetTokens = tokens
+		req.SnippetFragments = fragments
 	}
 }
 
-func (agent *searchAgent) Search(
-	q string, spaces []string, pageLimit int, pageOffset int,
-) (
-	res protocol.SearchResponse,
-	err error,
-) {
+// WithExclude leaves the given documents out of the hits
+func WithExclude(docs ...protocol.DocumentLocator) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.Exclude = append(req.Exclude, docs...)
+	}
+}
 
-	numShards, err := agent.getNumShards()
-	if err != nil {
-		return
+// WithSort sets the hit sort order
+func WithSort(order protocol.SortOrder) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.Sort = order
+	}
+}
+
+// WithRecencyBoost sorts hits by rank, boosted by up to weight
+// for recently updated documents. The boost is halved for documents
+// of age halfLife. Zero values use worker defaults.
+func WithRecencyBoost(weight float32, halfLife time.Duration) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.Sort = protocol.SortByRecency
+		req.RecencyWeight = weight
+		req.RecencyHalfLife = halfLife
+	}
+}
+
+// WithUpdatedBetween only matches documents updated in the
+// interval [from, to). Zero times leave the interval open.
+func WithUpdatedBetween(from time.Time, to time.Time) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.UpdatedFrom = from
+		req.UpdatedTo = to
 	}
-	shardedLimit := pageLimit / int(numShards)
-	if shardedLimit < 1 {
-		shardedLimit = 1
+}
+
+// WithUpdatedWithin only matches documents updated within the given
+// duration before the search, like the last 30 days.
+func WithUpdatedWithin(within time.Duration) SearchOption {
+	return func(req *protocol.SearchRequest) {
+		req.UpdatedWithin = within
 	}
+}
+
+// NewSearchRequest creates a search request with the given options applied,
+// as performed by SearchAgent.Search. Used for building SearchBatch requests.
+func NewSearchRequest(
+	q string, spaces []string, pageLimit int, pageOffset int, options ...SearchOption,
+) protocol.SearchRequest {
 	req := protocol.SearchRequest{
 		Spaces:     spaces,
 		Query:      q,
-		PageLimit:  uint16(shardedLimit),
+		PageLimit:  uint16(pageLimit),
 		PageOffset: uint16(pageOffset),
 	}
+	for _, option := range options {
+		option(&req)
+	}
+	return req
+}
 
-	inbox := agent.conn.Conn.NewRespInbox()
-	responseCh := make(chan protocol.SearchResponse, numShards)
-	defer func() {
-		close(responseCh)
-		responseCh = nil
-	}()
-	sub, err := agent.conn.Subscribe(inbox, func(response *protocol.SearchResponse) {
-		if responseCh != nil {
-			clone := *response
-			clone.Result.Hits = append(clone.Result.Hits[:0:0], clone.Result.Hits...)
-			responseCh <- *response
-		}
-	})
+// setReferenceTime lets all shards use the same reference time,
+// unless set by the request or the request cursor
+func setReferenceTime(req *protocol.SearchRequest) {
+	if req.Now.IsZero() && (req.After == "" || req.After == protocol.CursorStart) {
+		req.Now = time.Now()
+	}
+}
+
+// setRequestTimeout passes the time left until the context deadline
+// on to the workers
+func setRequestTimeout(ctx context.Context, req *protocol.SearchRequest) {
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline)
+	}
+}
+
+// NewSearchAgent - SearchAgent constructor
+func NewSearchAgent(URLs []string, options ...Option) (SearchAgent, error) {
+	agent := &searchAgent{}
+	err := agent.connect(URLs, options)
 	if err != nil {
-		return
+		return nil, err
 	}
-	err = sub.AutoUnsubscribe(int(numShards))
+	return agent, nil
+}
+
+type searchAgent struct {
+	clusterAgent
+}
+
+func (agent *searchAgent) Search(
+	q string, spaces []string, pageLimit int, pageOffset int, options ...SearchOption,
+) (
+	res protocol.SearchResponse,
+	err error,
+) {
+	return agent.SearchContext(context.Background(), q, spaces, pageLimit, pageOffset, options...)
+}
+
+func (agent *searchAgent) SearchContext(
+	ctx context.Context, q string, spaces []string, pageLimit int, pageOffset int, options ...SearchOption,
+) (
+	res protocol.SearchResponse,
+	err error,
+) {
+
+	numShards, err := agent.getNumShards(ctx)
 	if err != nil {
 		return
 	}
-	err = agent.conn.PublishRequest(agent.topic+".q", inbox, req)
+	req := NewSearchRequest(q, spaces, pageLimit, pageOffset, options...)
+	setReferenceTime(&req)
+
+	// One deadline covers all fetch rounds
+	ctx, cancel := agent.withTimeout(ctx)
+	defer cancel()
+
+	return searchShards(func(shardReq protocol.SearchRequest) ([]protocol.SearchResponse, error) {
+		return agent.roundtrip(ctx, shardReq, numShards)
+	}, req, int(numShards))
+}
+
+// SearchSimilar searches for documents similar to the given document.
+// The most distinctive terms of the document are fetched from the shard
+// owning it, and any of them are then searched for in all shards.
+// The source document is not included in the hits.
+func (agent *searchAgent) SearchSimilar(
+	space string, id protocol.DocumentID, spaces []string, pageLimit int, pageOffset int, options ...SearchOption,
+) (
+	res protocol.SearchResponse,
+	err error,
+) {
+	return agent.SearchSimilarContext(context.Background(), space, id, spaces, pageLimit, pageOffset, options...)
+}
+
+func (agent *searchAgent) SearchSimilarContext(
+	ctx context.Context, space string, id protocol.DocumentID, spaces []string,
+	pageLimit int, pageOffset int, options ...SearchOption,
+) (
+	res protocol.SearchResponse,
+	err error,
+) {
+	var similar protocol.SimilarResponse
+	err = agent.requestShard(ctx, "similar", id, protocol.SimilarRequest{Space: space, ID: id}, &similar)
 	if err != nil {
 		return
 	}
-	timeout := time.After(agent.timeout)
-	var responses []protocol.SearchResponse
-
-waitLoop:
-	for {
-		select {
-		case <-timeout:
-			_ = sub.Unsubscribe()
-			err = fmt.Errorf("timeout waiting for search response")
-			return
-		case response := <-responseCh:
-			responses = append(responses, response)
-			if len(responses) == int(numShards) {
-				break waitLoop
-			}
-		}
+	if similar.Status != protocol.SearchStatusIndexHit {
+		res.Status = similar.Status
+		res.Duration = similar.Duration
+		return
+	}
+
+	source := protocol.DocumentLocator{Space: space, ID: id}
+	options = append(options, WithExclude(source))
+	return agent.SearchContext(ctx, similarQuery(similar.Terms), spaces, pageLimit, pageOffset, options...)
+}
+
+// similarQuery combines terms to a query matching any of them.
+// Terms are quoted to never be taken for operators.
+func similarQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, "") + `"`
 	}
+	return strings.Join(quoted, " OR ")
+}
+
+// GetDocument fetches a document as stored in the index,
+// from the shard owning it.
+func (agent *searchAgent) GetDocument(space string, id protocol.DocumentID) (
+	res protocol.DocumentGetResponse,
+	err error,
+) {
+	return agent.GetDocumentContext(context.Background(), space, id)
+}
 
-	res = mergeResponses(responses)
+func (agent *searchAgent) GetDocumentContext(ctx context.Context, space string, id protocol.DocumentID) (
+	res protocol.DocumentGetResponse,
+	err error,
+) {
+	err = agent.requestShard(ctx, "document.get", id, protocol.DocumentGetRequest{Space: space, ID: id}, &res)
 	return
 }
 
-func mergeResponses(responses []protocol.SearchResponse) protocol.SearchResponse {
-	var merged protocol.SearchResponse
-	for _, response := range responses {
-		if merged.Duration < response.Duration {
-			merged.Duration = response.Duration
-		}
-		if merged.Status < response.Status {
-			merged.Status = response.Status
-		}
-		merged.Result.Capped = merged.Result.Capped || response.Result.Capped
-		merged.Result.TotalHits += response.Result.TotalHits
-		merged.Result.Hits = append(merged.Result.Hits, response.Result.Hits...)
-
-		// Keep the respelt version with the lowest distance
-		if merged.Result.Respelt == "" ||
-			(response.Result.RespeltDistance > 0 && merged.Result.RespeltDistance > response.Result.RespeltDistance) {
-			merged.Result.Respelt = response.Result.Respelt
-			merged.Result.RespeltDistance = response.Result.RespeltDistance
+// roundtrip sends a search request to all shards and waits for
+// one response per shard, until the context deadline. Shards not
+// responding in time for partial results get empty timeout responses.
+func (agent *searchAgent) roundtrip(
+	ctx context.Context, req protocol.SearchRequest, numShards int32,
+) (
+	responses []protocol.SearchResponse,
+	err error,
+) {
+	setRequestTimeout(ctx, &req)
+	messages, err := agent.requestShards(ctx, "q", req, numShards, agent.partial)
+	if err != nil {
+		return
+	}
+	for _, message := range messages {
+		var response protocol.SearchResponse
+		err = agent.conn.Enc.Decode(message.Subject, message.Data, &response)
+		if err != nil {
+			return nil, err
 		}
+		responses = append(responses, response)
 	}
-	sort.SliceStable(merged.Result.Hits, func(a, b int) bool {
-		return merged.Result.Hits[a].Rank < merged.Result.Hits[b].Rank
-	})
-	return merged
+	responses = append(responses, timedOutShards(responses, int(numShards))...)
+	return
 }
@@ -0,0 +1,169 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+// batchFetcher sends a batch of search requests to all shards of a
+// shard group and returns one response per shard for each request.
+type batchFetcher func([]protocol.SearchRequest) ([][]protocol.SearchResponse, error)
+
+// searchShardsBatch performs several searches over all shards.
+// Each search is run by searchShards, and the shard requests of all
+// searches are collected and sent as one batch per round.
+// Searches failing for other reasons than the batch roundtrip get
+// the query error status, without failing the other searches.
+func searchShardsBatch(
+	fetch batchFetcher, reqs []protocol.SearchRequest, numShards int,
+) ([]protocol.SearchResponse, error) {
+
+	type fetchResult struct {
+		responses []protocol.SearchResponse
+		err       error
+	}
+	type fetchRequest struct {
+		req    protocol.SearchRequest
+		result chan fetchResult
+	}
+
+	requests := make(chan fetchRequest)
+	done := make(chan bool)
+	results := make([]protocol.SearchResponse, len(reqs))
+	searchErrors := make([]error, len(reqs))
+
+	for i := range reqs {
+		go func(i int) {
+			results[i], searchErrors[i] = searchShards(func(req protocol.SearchRequest) ([]protocol.SearchResponse, error) {
+				result := make(chan fetchResult, 1)
+				requests <- fetchRequest{req, result}
+				fetched := <-result
+				return fetched.responses, fetched.err
+			}, reqs[i], numShards)
+			done <- true
+		}(i)
+	}
+
+	// A batch is sent when all unfinished searches are waiting for it
+	var fetchErr error
+	var pending []fetchRequest
+	for active := len(reqs); active > 0; {
+		select {
+		case request := <-requests:
+			pending = append(pending, request)
+		case <-done:
+			active--
+		}
+		if len(pending) == 0 || len(pending) < active {
+			continue
+		}
+		batch := make([]protocol.SearchRequest, len(pending))
+		for i, request := range pending {
+			batch[i] = request.req
+		}
+		responses, err := fetch(batch)
+		if err != nil && fetchErr == nil {
+			fetchErr = err
+		}
+		for i, request := range pending {
+			result := fetchResult{err: err}
+			if err == nil {
+				result.responses = responses[i]
+			}
+			request.result <- result
+		}
+		pending = nil
+	}
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	for i, err := range searchErrors {
+		if err != nil {
+			results[i] = protocol.SearchResponse{Status: protocol.SearchStatusQueryError}
+		}
+	}
+	return results, nil
+}
+
+func (agent *searchAgent) SearchBatch(reqs []protocol.SearchRequest) ([]protocol.SearchResponse, error) {
+	return agent.SearchBatchContext(context.Background(), reqs)
+}
+
+func (agent *searchAgent) SearchBatchContext(
+	ctx context.Context, reqs []protocol.SearchRequest,
+) ([]protocol.SearchResponse, error) {
+	if len(reqs) > protocol.MaxSearchBatchSize {
+		return nil, fmt.Errorf("too many requests in search batch, max is %d", protocol.MaxSearchBatchSize)
+	}
+	numShards, err := agent.getNumShards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prepared := make([]protocol.SearchRequest, len(reqs))
+	for i, req := range reqs {
+		setReferenceTime(&req)
+		prepared[i] = req
+	}
+
+	// One deadline covers all fetch rounds
+	ctx, cancel := agent.withTimeout(ctx)
+	defer cancel()
+
+	return searchShardsBatch(func(batch []protocol.SearchRequest) ([][]protocol.SearchResponse, error) {
+		return agent.batchRoundtrip(ctx, batch, numShards)
+	}, prepared, int(numShards))
+}
+
+// batchRoundtrip sends a search batch to all shards and waits for
+// one batch response per shard, until the context deadline.
+// The responses are regrouped per request, with empty timeout responses
+// for shards not responding in time for partial results.
+func (agent *searchAgent) batchRoundtrip(
+	ctx context.Context, batch []protocol.SearchRequest, numShards int32,
+) (
+	[][]protocol.SearchResponse, error,
+) {
+	for i := range batch {
+		setRequestTimeout(ctx, &batch[i])
+	}
+	messages, err := agent.requestShards(ctx, "batch", protocol.SearchBatchRequest{Requests: batch}, numShards, agent.partial)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([][]protocol.SearchResponse, len(batch))
+	for _, message := range messages {
+		var response protocol.SearchBatchResponse
+		err = agent.conn.Enc.Decode(message.Subject, message.Data, &response)
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Responses) != len(batch) {
+			return nil, fmt.Errorf("unexpected search batch response length %d, expected %d",
+				len(response.Responses), len(batch))
+		}
+		for i, shardResponse := range response.Responses {
+			responses[i] = append(responses[i], shardResponse)
+		}
+	}
+	for i := range responses {
+		responses[i] = append(responses[i], timedOutShards(responses[i], int(numShards))...)
+	}
+	return responses, nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+// clusterAgent is the base of agents sending requests to all
+// shards of a cluster. The shard group size is discovered using
+// a status monitor, unless forced.
+type clusterAgent struct {
+	state
+	volatileNumShards int32
+	monitor           Monitor
+	timeout           time.Duration
+	partial           bool
+}
+
+// WithShardgroupSize forces shard group size instead of using discovery
+func WithShardgroupSize(groupSize int32) Option {
+	return func(st *state) {
+		agent := st.local.(*clusterAgent)
+		agent.volatileNumShards = groupSize
+	}
+}
+
+// WithTimeout sets the request timeout, covering all fetch rounds of a request
+func WithTimeout(timeout time.Duration) Option {
+	return func(st *state) {
+		agent := st.local.(*clusterAgent)
+		agent.timeout = timeout
+	}
+}
+
+// WithPartialResults returns the merged results of the shards
+// responding before the timeout, instead of failing the request.
+// Only applies to searches, which then are marked as partial.
+func WithPartialResults() Option {
+	return func(st *state) {
+		agent := st.local.(*clusterAgent)
+		agent.partial = true
+	}
+}
+
+func (agent *clusterAgent) connect(URLs []string, options []Option) error {
+	agent.topic = "leta"
+	agent.onError = func(error) {}
+	agent.timeout = time.Second * 2
+	agent.local = agent
+	agent.apply(options)
+
+	ec, err := connect(URLs, agent.state)
+	if err != nil {
+		return err
+	}
+
+	agent.conn = ec
+
+	if agent.volatileNumShards == 0 {
+		agent.monitor, err = NewMonitor(
+			URLs,
+			func(status protocol.IndexStatus) {
+				if status.Status == protocol.IndexStatusInSync || status.Status == protocol.IndexStatusSyncing {
+					atomic.SwapInt32(&agent.volatileNumShards, int32(status.ShardgroupSize))
+				}
+			},
+			WithTopic(agent.topic),
+			WithSeedFile(agent.seedFile),
+			WithRootCAs(agent.rootCAs...),
+		)
+		if err != nil {
+			agent.conn.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (agent *clusterAgent) Close() {
+	if agent.monitor != nil {
+		agent.monitor.Close()
+	}
+
+	agent.conn.Close()
+}
+
+// getNumShards waits for the shard group size to be discovered,
+// for at most five seconds.
+func (agent *clusterAgent) getNumShards(ctx context.Context) (int32, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	for {
+		numShards := atomic.LoadInt32(&agent.volatileNumShards)
+		if numShards != 0 {
+			return numShards, nil
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.Canceled {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("timeout waiting for cluster: %w", ctx.Err())
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+// withTimeout limits a request context by the agent timeout
+func (agent *clusterAgent) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, agent.timeout)
+}
+
+// requestShards sends a request to all shards and waits for
+// one response message per shard, until the context is done.
+// When partial is set, the responses received before the deadline
+// are returned, unless there are none.
+func (agent *clusterAgent) requestShards(
+	ctx context.Context, subject string, req interface{}, numShards int32, partial bool,
+) (
+	responses []*nats.Msg,
+	err error,
+) {
+	inbox := agent.conn.Conn.NewRespInbox()
+	responseCh := make(chan *nats.Msg, numShards)
+	sub, err := agent.conn.Conn.ChanSubscribe(inbox, responseCh)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+	err = sub.AutoUnsubscribe(int(numShards))
+	if err != nil {
+		return
+	}
+	err = agent.conn.PublishRequest(agent.topic+"."+subject, inbox, req)
+	if err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.Canceled {
+				err = ctx.Err()
+				return
+			}
+			if partial && len(responses) > 0 {
+				return
+			}
+			err = fmt.Errorf("timeout waiting for search response: %w", ctx.Err())
+			return
+		case response := <-responseCh:
+			responses = append(responses, response)
+			if len(responses) == int(numShards) {
+				return
+			}
+		}
+	}
+}
+
+// requestShard sends a request to the shard owning a document,
+// and waits for the response.
+func (agent *clusterAgent) requestShard(
+	ctx context.Context, subject string, docID protocol.DocumentID, req interface{}, response interface{},
+) error {
+	numShards, err := agent.getNumShards(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := agent.withTimeout(ctx)
+	defer cancel()
+	shard := protocol.ShardString(protocol.ShardIndexFromDocumentID(docID, int(numShards)), int(numShards))
+	return agent.conn.RequestWithContext(ctx, agent.topic+"."+subject+"."+shard, req, response)
+}
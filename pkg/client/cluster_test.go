@@ -0,0 +1,57 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+	"github.com/erkkah/letarette/pkg/xt"
+)
+
+func TestGetNumShards_Canceled(t *testing.T) {
+	xt := xt.X(t)
+
+	agent := clusterAgent{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	_, err := agent.getNumShards(ctx)
+	xt.Equal(err, context.Canceled)
+	xt.True(time.Since(start) < time.Second)
+
+	agent.volatileNumShards = 3
+	numShards, err := agent.getNumShards(context.Background())
+	xt.Nil(err)
+	xt.Equal(numShards, int32(3))
+}
+
+func TestSetRequestTimeout(t *testing.T) {
+	xt := xt.X(t)
+
+	var req protocol.SearchRequest
+	setRequestTimeout(context.Background(), &req)
+	xt.Equal(req.Timeout, time.Duration(0))
+
+	agent := clusterAgent{timeout: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx, cancelAgent := agent.withTimeout(ctx)
+	defer cancelAgent()
+	setRequestTimeout(ctx, &req)
+	xt.True(req.Timeout > 0 && req.Timeout <= time.Second)
+}
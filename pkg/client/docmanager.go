@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/erkkah/letarette/pkg/protocol"
 	"github.com/nats-io/nats.go"
@@ -40,13 +41,20 @@ type DocumentManager interface {
 
 type manager struct {
 	state
-	ctx    context.Context
-	cancel context.CancelFunc
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
 }
 
 // StartDocumentManager creates a DocumentManager and connects to Nats daemon
 func StartDocumentManager(URLs []string, options ...Option) (DocumentManager, error) {
-	ctx, cancel := context.WithCancel(context.Background())
+	return StartDocumentManagerContext(context.Background(), URLs, options...)
+}
+
+// StartDocumentManagerContext creates a DocumentManager that is closed when
+// the context is done. Handlers are called with contexts derived from it.
+func StartDocumentManagerContext(ctx context.Context, URLs []string, options ...Option) (DocumentManager, error) {
+	ctx, cancel := context.WithCancel(ctx)
 	mgr := &manager{
 		state: state{
 			topic:   "leta",
@@ -61,17 +69,23 @@ func StartDocumentManager(URLs []string, options ...Option) (DocumentManager, er
 
 	ec, err := connect(URLs, mgr.state)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	mgr.conn = ec
 
+	go func() {
+		<-ctx.Done()
+		mgr.Close()
+	}()
+
 	return mgr, nil
 }
 
 func (m *manager) Close() {
 	m.cancel()
-	m.conn.Close()
+	m.closeOnce.Do(m.conn.Close)
 }
 
 func (m *manager) StartIndexRequestHandler(handler IndexRequestHandler) error {
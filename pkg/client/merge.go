@@ -0,0 +1,387 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+// maxShardPageLimit is the largest page size served by workers
+const maxShardPageLimit = 500
+
+// shardFetcher sends a search request to all shards of a shard group
+// and returns one response per shard.
+type shardFetcher func(protocol.SearchRequest) ([]protocol.SearchResponse, error)
+
+// searchShards performs a search over all shards and returns the requested page
+// of the globally ordered hits.
+//
+// Each shard returns its own top (offset+1)*limit candidates, fetched in as
+// many rounds as needed to stay within the worker page size limit.
+// The sorted candidate lists are then k-way merged and sliced to get the page.
+func searchShards(
+	fetch shardFetcher, req protocol.SearchRequest, numShards int,
+) (protocol.SearchResponse, error) {
+
+	if req.After != "" {
+		return searchShardsAfter(fetch, req)
+	}
+
+	if numShards == 1 {
+		responses, err := fetch(req)
+		if err != nil {
+			return protocol.SearchResponse{}, err
+		}
+		merged := mergeResponses(responses, req.Sort)
+		end := int(req.PageOffset)*int(req.PageLimit) + len(merged.Result.Hits)
+		setCursor(&merged, req, merged.Result.TotalHits-end)
+		return merged, nil
+	}
+
+	limit := max(int(req.PageLimit), 1)
+	offset := int(req.PageOffset) * limit
+	wanted := offset + limit
+	pageLimit := min(wanted, maxShardPageLimit)
+
+	var merged protocol.SearchResponse
+	var candidates [][]protocol.SearchHit
+
+	for page := 0; page*pageLimit < wanted; page++ {
+		shardReq := req
+		shardReq.PageLimit = uint16(pageLimit)
+		shardReq.PageOffset = uint16(page)
+		if page > 0 {
+			// Facets are only merged from the first round
+			shardReq.SpaceFacets = false
+			shardReq.FieldFacets = nil
+		}
+
+		responses, err := fetch(shardReq)
+		if err != nil {
+			return protocol.SearchResponse{}, err
+		}
+
+		exhausted := true
+		for _, response := range responses {
+			candidates = append(candidates, response.Result.Hits)
+			if len(response.Result.Hits) == pageLimit {
+				exhausted = false
+			}
+		}
+
+		round := mergeResponses(responses, req.Sort)
+		if page == 0 {
+			merged = round
+		} else {
+			merged.Duration += round.Duration
+			merged.Status = max(merged.Status, round.Status)
+			merged.Result.Partial = merged.Result.Partial || round.Result.Partial
+			merged.Shards = mergeShardStatus(merged.Shards, round.Shards)
+		}
+
+		if exhausted {
+			break
+		}
+	}
+
+	hits := mergeHits(candidates, req.Sort)
+	if offset > len(hits) {
+		offset = len(hits)
+	}
+	end := min(offset+limit, len(hits))
+	merged.Result.Hits = hits[offset:end]
+	setCursor(&merged, req, merged.Result.TotalHits-end)
+
+	return merged, nil
+}
+
+// searchShardsAfter performs a cursor search over all shards.
+// Each shard returns its first page of hits after the cursor,
+// and the merged page is cut at the shortest possible page length.
+func searchShardsAfter(fetch shardFetcher, req protocol.SearchRequest) (protocol.SearchResponse, error) {
+	var after *protocol.SearchCursor
+	if req.After != protocol.CursorStart {
+		cursor, err := protocol.ParseSearchCursor(req.After)
+		if err != nil {
+			return protocol.SearchResponse{}, err
+		}
+		if req.Now.IsZero() {
+			req.Now = cursor.Now
+		}
+		after = &cursor
+	}
+
+	limit := min(max(int(req.PageLimit), 1), maxShardPageLimit)
+	req.PageLimit = uint16(limit)
+	req.PageOffset = 0
+
+	responses, err := fetch(req)
+	if err != nil {
+		return protocol.SearchResponse{}, err
+	}
+	merged := mergeResponses(responses, req.Sort)
+	if len(merged.Result.Hits) > limit {
+		merged.Result.Hits = merged.Result.Hits[:limit]
+	}
+	if after != nil {
+		// Shards only count hits at the start of a walk
+		merged.Result.TotalHits = after.Remaining
+	}
+	setCursor(&merged, req, merged.Result.TotalHits-len(merged.Result.Hits))
+	return merged, nil
+}
+
+// setCursor positions the response cursor at the last merged hit,
+// followed by the given number of remaining hits
+func setCursor(response *protocol.SearchResponse, req protocol.SearchRequest, remaining int) {
+	hits := response.Result.Hits
+	response.Result.Cursor = ""
+	if len(hits) > 0 {
+		response.Result.Cursor = protocol.NewSearchCursor(hits[len(hits)-1], req.Sort, req.Now, remaining)
+	}
+}
+
+func mergeResponses(responses []protocol.SearchResponse, order protocol.SortOrder) protocol.SearchResponse {
+	var merged protocol.SearchResponse
+	var hits [][]protocol.SearchHit
+	var corrections []protocol.Correction
+	maxCorrections := 0
+	for _, response := range responses {
+		if merged.Duration < response.Duration {
+			merged.Duration = response.Duration
+		}
+		if merged.Status < response.Status {
+			merged.Status = response.Status
+		}
+		merged.Result.Capped = merged.Result.Capped || response.Result.Capped
+		merged.Result.Partial = merged.Result.Partial || response.Result.Partial
+		merged.Shards = mergeShardStatus(merged.Shards, response.Shards)
+		merged.Result.TotalHits += response.Result.TotalHits
+		hits = append(hits, response.Result.Hits)
+		merged.Explanations = append(merged.Explanations, response.Explanations...)
+		mergeFacets(&merged.Result.Facets, response.Result.Facets)
+		corrections = append(corrections, response.Result.Corrections...)
+		maxCorrections = max(maxCorrections, len(response.Result.Corrections))
+	}
+	merged.Result.Hits = mergeHits(hits, order)
+	mergeCorrections(&merged.Result, corrections, maxCorrections)
+	return merged
+}
+
+// mergeCorrections sums the hits of equal corrections from all shards, and
+// keeps the limit best corrections finding more hits than the merged query.
+// The best correction is set as the respelt query.
+//
+// Shards only count corrections for queries with few local hits, so the
+// summed hits are lower bounds, missing the hits of shards that returned
+// no corrections. Corrections can therefore be dropped even though they
+// would find more hits than the merged query.
+func mergeCorrections(result *protocol.SearchResult, corrections []protocol.Correction, limit int) {
+	var merged []protocol.Correction
+	index := map[string]int{}
+	for _, correction := range corrections {
+		if i, found := index[correction.Query]; found {
+			merged[i].Hits += correction.Hits
+			merged[i].Distance = min(merged[i].Distance, correction.Distance)
+			continue
+		}
+		index[correction.Query] = len(merged)
+		merged = append(merged, correction)
+	}
+
+	result.Corrections = nil
+	for _, correction := range merged {
+		if correction.Hits > result.TotalHits {
+			result.Corrections = append(result.Corrections, correction)
+		}
+	}
+	sort.SliceStable(result.Corrections, func(a, b int) bool {
+		ca, cb := result.Corrections[a], result.Corrections[b]
+		if ca.Distance != cb.Distance {
+			return ca.Distance < cb.Distance
+		}
+		if ca.Hits != cb.Hits {
+			return ca.Hits > cb.Hits
+		}
+		return ca.Query < cb.Query
+	})
+	if len(result.Corrections) > limit {
+		result.Corrections = result.Corrections[:limit]
+	}
+
+	result.Respelt = ""
+	result.RespeltDistance = 0
+	if len(result.Corrections) > 0 {
+		result.Respelt = result.Corrections[0].Query
+		result.RespeltDistance = result.Corrections[0].Distance
+	}
+}
+
+// timedOutShards creates empty responses for the shards of a shard group
+// missing among the given responses, marked as partial and timed out.
+func timedOutShards(responses []protocol.SearchResponse, numShards int) []protocol.SearchResponse {
+	responded := map[string]bool{}
+	for _, response := range responses {
+		for _, status := range response.Shards {
+			responded[status.Shard] = true
+		}
+	}
+	missing := numShards - len(responses)
+	var timedOut []protocol.SearchResponse
+	for i := 0; i < numShards && len(timedOut) < missing; i++ {
+		shard := protocol.ShardString(i, numShards)
+		if responded[shard] {
+			continue
+		}
+		timedOut = append(timedOut, protocol.SearchResponse{
+			Result: protocol.SearchResult{Partial: true},
+			Shards: []protocol.ShardStatus{{Shard: shard, Status: protocol.SearchStatusTimeout}},
+		})
+	}
+	return timedOut
+}
+
+// mergeShardStatus adds shard statuses to the merged statuses, keeping
+// the worst status of each shard. The merged statuses are kept in shard order.
+func mergeShardStatus(merged []protocol.ShardStatus, statuses []protocol.ShardStatus) []protocol.ShardStatus {
+	worse := func(a, b protocol.ShardStatus) bool {
+		if a.Responded != b.Responded {
+			return !a.Responded
+		}
+		return a.Status > b.Status
+	}
+	for _, status := range statuses {
+		found := false
+		for i := range merged {
+			if merged[i].Shard == status.Shard {
+				found = true
+				if worse(status, merged[i]) {
+					merged[i] = status
+				}
+			}
+		}
+		if !found {
+			merged = append(merged, status)
+		}
+	}
+	sort.SliceStable(merged, func(a, b int) bool {
+		return shardIndex(merged[a].Shard) < shardIndex(merged[b].Shard)
+	})
+	return merged
+}
+
+// shardIndex parses the shard number of a shard string like "1/3"
+func shardIndex(shard string) int {
+	var index, size int
+	_, _ = fmt.Sscanf(shard, "%d/%d", &index, &size)
+	return index
+}
+
+// mergeFacets adds the facet counts of a shard to the merged counts
+func mergeFacets(merged *protocol.SearchFacets, facets protocol.SearchFacets) {
+	if facets.Spaces != nil && merged.Spaces == nil {
+		merged.Spaces = map[string]int{}
+	}
+	for space, count := range facets.Spaces {
+		merged.Spaces[space] += count
+	}
+	if facets.Fields != nil && merged.Fields == nil {
+		merged.Fields = map[string]map[string]int{}
+	}
+	for field, values := range facets.Fields {
+		if merged.Fields[field] == nil {
+			merged.Fields[field] = map[string]int{}
+		}
+		for value, count := range values {
+			merged.Fields[field][value] += count
+		}
+	}
+}
+
+// hitLess orders hits the same way as the worker search strategies,
+// with space and document ID as tie breakers.
+func hitLess(a, b protocol.SearchHit, order protocol.SortOrder) bool {
+	if order == protocol.SortByDate && !a.Updated.Equal(b.Updated) {
+		return a.Updated.After(b.Updated)
+	}
+	if order != protocol.SortByDate && a.Fuzzy != b.Fuzzy {
+		return b.Fuzzy
+	}
+	if a.Rank != b.Rank {
+		return a.Rank < b.Rank
+	}
+	if a.Space != b.Space {
+		return a.Space < b.Space
+	}
+	return a.ID < b.ID
+}
+
+// mergeHits k-way merges lists of hits, each sorted by hitLess.
+func mergeHits(lists [][]protocol.SearchHit, order protocol.SortOrder) []protocol.SearchHit {
+	total := 0
+	h := hitHeap{order: order}
+	for _, list := range lists {
+		if len(list) > 0 {
+			h.lists = append(h.lists, list)
+		}
+		total += len(list)
+	}
+	heap.Init(&h)
+
+	merged := make([]protocol.SearchHit, 0, total)
+	for h.Len() > 0 {
+		list := h.lists[0]
+		merged = append(merged, list[0])
+		if len(list) > 1 {
+			h.lists[0] = list[1:]
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return merged
+}
+
+// hitHeap is a heap of non-empty hit lists, ordered by their first hit
+type hitHeap struct {
+	lists [][]protocol.SearchHit
+	order protocol.SortOrder
+}
+
+func (h hitHeap) Len() int {
+	return len(h.lists)
+}
+
+func (h hitHeap) Less(i, j int) bool {
+	return hitLess(h.lists[i][0], h.lists[j][0], h.order)
+}
+
+func (h hitHeap) Swap(i, j int) {
+	h.lists[i], h.lists[j] = h.lists[j], h.lists[i]
+}
+
+func (h *hitHeap) Push(x interface{}) {
+	h.lists = append(h.lists, x.([]protocol.SearchHit))
+}
+
+func (h *hitHeap) Pop() interface{} {
+	last := h.lists[len(h.lists)-1]
+	h.lists = h.lists[:len(h.lists)-1]
+	return last
+}
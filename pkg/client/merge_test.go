@@ -0,0 +1,351 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+	"github.com/erkkah/letarette/pkg/xt"
+)
+
+// fakeShard serves pages of hits like a worker would
+type fakeShard []protocol.SearchHit
+
+func (shard fakeShard) search(req protocol.SearchRequest) protocol.SearchResponse {
+	hits := append(shard[:0:0], shard...)
+	sort.Slice(hits, func(a, b int) bool {
+		return hitLess(hits[a], hits[b], req.Sort)
+	})
+	if req.After != "" && req.After != protocol.CursorStart {
+		cursor, _ := protocol.ParseSearchCursor(req.After)
+		last := protocol.SearchHit{
+			Space: cursor.Space, ID: cursor.ID, Fuzzy: cursor.Fuzzy, Rank: cursor.Rank, Updated: cursor.Updated,
+		}
+		for len(hits) > 0 && !hitLess(last, hits[0], req.Sort) {
+			hits = hits[1:]
+		}
+		req.PageOffset = 0
+	}
+	limit := min(int(req.PageLimit), maxShardPageLimit)
+	offset := min(int(req.PageOffset)*limit, len(hits))
+	end := min(offset+limit, len(hits))
+	return protocol.SearchResponse{
+		Result: protocol.SearchResult{
+			Hits:      hits[offset:end],
+			TotalHits: len(hits),
+		},
+		Status: protocol.SearchStatusIndexHit,
+	}
+}
+
+func fakeCluster(shards ...fakeShard) shardFetcher {
+	return func(req protocol.SearchRequest) ([]protocol.SearchResponse, error) {
+		var responses []protocol.SearchResponse
+		for _, shard := range shards {
+			responses = append(responses, shard.search(req))
+		}
+		return responses, nil
+	}
+}
+
+func fakeHits(count int) []protocol.SearchHit {
+	random := rand.New(rand.NewSource(4711))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	hits := make([]protocol.SearchHit, count)
+	for i := range hits {
+		hits[i] = protocol.SearchHit{
+			Space: "docs",
+			ID:    protocol.DocumentID(fmt.Sprintf("doc%04d", i)),
+			// Few distinct ranks and dates to get plenty of ties
+			Rank:    -float64(random.Intn(40)),
+			Updated: start.Add(time.Duration(random.Intn(100)) * time.Hour),
+			Fuzzy:   random.Intn(4) == 0,
+		}
+	}
+	return hits
+}
+
+func splitRoundRobin(hits []protocol.SearchHit, numShards int) []fakeShard {
+	shards := make([]fakeShard, numShards)
+	for i, hit := range hits {
+		shards[i%numShards] = append(shards[i%numShards], hit)
+	}
+	return shards
+}
+
+func splitSkewed(hits []protocol.SearchHit, numShards int) []fakeShard {
+	sorted := append(hits[:0:0], hits...)
+	sort.Slice(sorted, func(a, b int) bool {
+		return hitLess(sorted[a], sorted[b], protocol.SortByRank)
+	})
+	// All of the best hits end up in the first shard
+	shards := make([]fakeShard, numShards)
+	shards[0] = sorted[:len(sorted)/2]
+	for i, hit := range sorted[len(sorted)/2:] {
+		shard := 1 + i%(numShards-1)
+		shards[shard] = append(shards[shard], hit)
+	}
+	return shards
+}
+
+func testShardedPagination(t *testing.T, shards []fakeShard) {
+	xt := xt.X(t)
+
+	var all fakeShard
+	for _, shard := range shards {
+		all = append(all, shard...)
+	}
+	single := fakeCluster(all)
+	sharded := fakeCluster(shards...)
+
+	for _, order := range []protocol.SortOrder{protocol.SortByRank, protocol.SortByDate} {
+		for _, limit := range []int{1, 7, 10, 50, 300} {
+			for _, page := range []int{0, 1, 3, 20} {
+				req := protocol.SearchRequest{
+					PageLimit:  uint16(limit),
+					PageOffset: uint16(page),
+					Sort:       order,
+				}
+				expected, err := searchShards(single, req, 1)
+				xt.Nil(err)
+				actual, err := searchShards(sharded, req, len(shards))
+				xt.Nil(err)
+
+				xt.Equalf(actual.Result.TotalHits, expected.Result.TotalHits,
+					"total hits, order %v, limit %v, page %v", order, limit, page)
+				xt.DeepEqualf(actual.Result.Hits, expected.Result.Hits,
+					"hits, order %v, limit %v, page %v", order, limit, page)
+			}
+		}
+	}
+}
+
+func TestShardedPagination_Even(t *testing.T) {
+	testShardedPagination(t, splitRoundRobin(fakeHits(1000), 3))
+}
+
+func TestShardedPagination_Skewed(t *testing.T) {
+	testShardedPagination(t, splitSkewed(fakeHits(1000), 4))
+}
+
+func TestShardedPagination_PastEnd(t *testing.T) {
+	xt := xt.X(t)
+
+	shards := splitRoundRobin(fakeHits(20), 3)
+	res, err := searchShards(fakeCluster(shards...), protocol.SearchRequest{
+		PageLimit:  10,
+		PageOffset: 5,
+	}, len(shards))
+	xt.Nil(err)
+	xt.Equal(len(res.Result.Hits), 0)
+	xt.Equal(res.Result.TotalHits, 20)
+}
+
+func TestMergeHits(t *testing.T) {
+	xt := xt.X(t)
+
+	hits := fakeHits(100)
+	var lists [][]protocol.SearchHit
+	for _, shard := range splitRoundRobin(hits, 5) {
+		lists = append(lists, shard.search(protocol.SearchRequest{PageLimit: 100}).Result.Hits)
+	}
+	merged := mergeHits(lists, protocol.SortByRank)
+
+	sort.Slice(hits, func(a, b int) bool {
+		return hitLess(hits[a], hits[b], protocol.SortByRank)
+	})
+	xt.DeepEqual(merged, hits)
+}
+
+func TestShardedCursor(t *testing.T) {
+	xt := xt.X(t)
+
+	all := fakeHits(1000)
+	shards := splitSkewed(all, 3)
+	cluster := fakeCluster(shards...)
+
+	for _, order := range []protocol.SortOrder{protocol.SortByRank, protocol.SortByDate} {
+		expected := append(all[:0:0], all...)
+		sort.Slice(expected, func(a, b int) bool {
+			return hitLess(expected[a], expected[b], order)
+		})
+
+		var walked []protocol.SearchHit
+		req := protocol.SearchRequest{PageLimit: 33, Sort: order, After: protocol.CursorStart}
+		for {
+			res, err := searchShards(cluster, req, len(shards))
+			xt.Nil(err)
+			xt.Equal(res.Result.TotalHits, len(expected)-len(walked))
+			if len(res.Result.Hits) == 0 {
+				xt.Equal(res.Result.Cursor, "")
+				break
+			}
+			walked = append(walked, res.Result.Hits...)
+			req.After = res.Result.Cursor
+		}
+		xt.DeepEqual(walked, expected)
+	}
+}
+
+func TestMergeFacets(t *testing.T) {
+	xt := xt.X(t)
+
+	responses := []protocol.SearchResponse{
+		{Result: protocol.SearchResult{Facets: protocol.SearchFacets{
+			Spaces: map[string]int{"docs": 3, "wiki": 1},
+			Fields: map[string]map[string]int{"tag": {"finance": 2, "hr": 1}},
+		}}},
+		{Result: protocol.SearchResult{}},
+		{Result: protocol.SearchResult{Facets: protocol.SearchFacets{
+			Spaces: map[string]int{"docs": 2},
+			Fields: map[string]map[string]int{"tag": {"finance": 1, "fun": 4}, "author": {}},
+		}}},
+	}
+	merged := mergeResponses(responses, protocol.SortByRank)
+	xt.DeepEqual(merged.Result.Facets, protocol.SearchFacets{
+		Spaces: map[string]int{"docs": 5, "wiki": 1},
+		Fields: map[string]map[string]int{
+			"tag":    {"finance": 3, "hr": 1, "fun": 4},
+			"author": {},
+		},
+	})
+}
+
+func TestMergeSuggestions(t *testing.T) {
+	xt := xt.X(t)
+
+	responses := []protocol.SuggestResponse{
+		{Status: protocol.SearchStatusIndexHit, Suggestions: []protocol.Suggestion{
+			{Term: "budget", Count: 5}, {Term: "bus", Count: 2}, {Term: "bulk", Count: 1},
+		}},
+		{Status: protocol.SearchStatusNoHit},
+		{Status: protocol.SearchStatusIndexHit, Suggestions: []protocol.Suggestion{
+			{Term: "bus", Count: 4}, {Term: "buy", Count: 3},
+		}},
+	}
+	merged := mergeSuggestions(responses, 3)
+	xt.Equal(merged.Status, protocol.SearchStatusIndexHit)
+	xt.DeepEqual(merged.Suggestions, []protocol.Suggestion{
+		{Term: "bus", Count: 6}, {Term: "budget", Count: 5}, {Term: "buy", Count: 3},
+	})
+}
+
+func TestMergeCorrections(t *testing.T) {
+	xt := xt.X(t)
+
+	responses := []protocol.SearchResponse{
+		{Result: protocol.SearchResult{TotalHits: 1, Corrections: []protocol.Correction{
+			{Query: "bang", Distance: 75, Hits: 4}, {Query: "bank", Distance: 75, Hits: 3},
+		}}},
+		{Result: protocol.SearchResult{TotalHits: 2}},
+		{Result: protocol.SearchResult{TotalHits: 0, Corrections: []protocol.Correction{
+			{Query: "bank", Distance: 75, Hits: 2}, {Query: "bond", Distance: 150, Hits: 9},
+			{Query: "bark", Distance: 100, Hits: 2},
+		}}},
+	}
+	merged := mergeResponses(responses, protocol.SortByRank)
+	xt.Equal(merged.Result.TotalHits, 3)
+	// Hits are summed, and corrections not finding more hits are dropped
+	xt.DeepEqual(merged.Result.Corrections, []protocol.Correction{
+		{Query: "bank", Distance: 75, Hits: 5},
+		{Query: "bang", Distance: 75, Hits: 4},
+		{Query: "bond", Distance: 150, Hits: 9},
+	})
+	xt.Equal(merged.Result.Respelt, "bank")
+	xt.Equal(merged.Result.RespeltDistance, float32(75))
+}
+
+func TestSimilarQuery(t *testing.T) {
+	xt := xt.X(t)
+
+	xt.Equal(similarQuery([]string{"budget", "or", `re"port`}), `"budget" OR "or" OR "report"`)
+}
+
+func TestSearchShardsBatch(t *testing.T) {
+	xt := xt.X(t)
+
+	shards := splitSkewed(fakeHits(1000), 3)
+	cluster := fakeCluster(shards...)
+	batches := 0
+	fetch := func(batch []protocol.SearchRequest) ([][]protocol.SearchResponse, error) {
+		batches++
+		var responses [][]protocol.SearchResponse
+		for _, req := range batch {
+			shardResponses, _ := cluster(req)
+			responses = append(responses, shardResponses)
+		}
+		return responses, nil
+	}
+
+	reqs := []protocol.SearchRequest{
+		{PageLimit: 10},
+		{PageLimit: 300, PageOffset: 3, Sort: protocol.SortByDate},
+		{PageLimit: 33, After: protocol.CursorStart},
+		{PageLimit: 10, After: "bogus"},
+	}
+	responses, err := searchShardsBatch(fetch, reqs, len(shards))
+	xt.Nil(err)
+	xt.Equal(len(responses), len(reqs))
+	// The largest page needs a second round to find the shards exhausted
+	xt.Equal(batches, 2)
+
+	for i, req := range reqs[:3] {
+		expected, err := searchShards(cluster, req, len(shards))
+		xt.Nil(err)
+		xt.DeepEqualf(responses[i], expected, "request %d", i)
+	}
+	xt.Equal(responses[3].Status, protocol.SearchStatusQueryError)
+}
+
+func TestSearchShardsPartial(t *testing.T) {
+	xt := xt.X(t)
+
+	shards := splitRoundRobin(fakeHits(3000), 3)
+	cluster := fakeCluster(shards...)
+	// The second shard responds to the first round only
+	round := 0
+	fetch := func(req protocol.SearchRequest) ([]protocol.SearchResponse, error) {
+		responses, _ := cluster(req)
+		for i := range responses {
+			shard := protocol.ShardString(i, len(shards))
+			responses[i].Shards = []protocol.ShardStatus{
+				{Shard: shard, Responded: true, Status: responses[i].Status},
+			}
+		}
+		if round > 0 {
+			responses = append(responses[:1], responses[2:]...)
+			responses = append(responses, timedOutShards(responses, len(shards))...)
+		}
+		round++
+		return responses, nil
+	}
+
+	res, err := searchShards(fetch, protocol.SearchRequest{PageLimit: 200, PageOffset: 3}, len(shards))
+	xt.Nil(err)
+	xt.Equal(round, 2)
+	xt.True(res.Result.Partial)
+	xt.Equal(res.Status, protocol.SearchStatusIndexHit)
+	xt.Equal(len(res.Shards), 3)
+	for i, status := range res.Shards {
+		xt.Equal(status.Shard, protocol.ShardString(i, len(shards)))
+		xt.Equal(status.Responded, i != 1)
+	}
+	xt.Equal(res.Shards[1].Status, protocol.SearchStatusTimeout)
+}
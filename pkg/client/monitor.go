@@ -15,6 +15,8 @@
 package client
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/erkkah/letarette/pkg/protocol"
@@ -30,12 +32,22 @@ type MonitorListener func(protocol.IndexStatus)
 
 // NewMonitor - Monitor constructor
 func NewMonitor(URLs []string, listener MonitorListener, options ...Option) (Monitor, error) {
+	return NewMonitorContext(context.Background(), URLs, listener, options...)
+}
+
+// NewMonitorContext creates a Monitor that is closed when the context is done
+func NewMonitorContext(
+	ctx context.Context, URLs []string, listener MonitorListener, options ...Option,
+) (Monitor, error) {
+	ctx, cancel := context.WithCancel(ctx)
 	client := &monitor{
 		state: state{
 			topic:   "leta",
 			onError: func(error) {},
 		},
 		listener: listener,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 
 	client.local = client
@@ -43,6 +55,7 @@ func NewMonitor(URLs []string, listener MonitorListener, options ...Option) (Mon
 
 	ec, err := connect(URLs, client.state)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
@@ -52,16 +65,23 @@ func NewMonitor(URLs []string, listener MonitorListener, options ...Option) (Mon
 		client.listener(*status)
 	})
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 
 	if client.metricsCollector != nil {
 		err = client.startMetricsCollector()
 		if err != nil {
+			client.Close()
 			return nil, err
 		}
 	}
 
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+
 	return client, nil
 }
 
@@ -75,7 +95,6 @@ func WithMetricsCollector(collector MetricsCollector, interval time.Duration) Op
 		m := st.local.(*monitor)
 		m.metricsCollector = collector
 		m.metricsInterval = interval
-		m.metricsDone = make(chan struct{})
 	}
 }
 
@@ -83,17 +102,17 @@ type monitor struct {
 	state
 	listener MonitorListener
 
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+
 	metricsCollector MetricsCollector
 	metricsInterval  time.Duration
-	metricsDone      chan struct{}
 }
 
 func (m *monitor) Close() {
-	m.conn.Close()
-	if m.metricsDone != nil {
-		close(m.metricsDone)
-		m.metricsDone = nil
-	}
+	m.cancel()
+	m.closeOnce.Do(m.conn.Close)
 }
 
 func (m *monitor) startMetricsCollector() error {
@@ -111,7 +130,7 @@ func (m *monitor) startMetricsCollector() error {
 				if m.onError != nil {
 					m.onError(err)
 				}
-			case <-m.metricsDone:
+			case <-m.ctx.Done():
 				_ = sub.Unsubscribe()
 				return
 			}
@@ -0,0 +1,126 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"sort"
+
+	"github.com/erkkah/letarette/pkg/protocol"
+)
+
+// SuggestAgent gets search term completions from a letarette cluster
+type SuggestAgent interface {
+	Close()
+	// Suggest returns the most common indexed terms starting with the
+	// given prefix, optionally counting documents in the given spaces only.
+	Suggest(prefix string, spaces []string, limit int) (protocol.SuggestResponse, error)
+	// SuggestContext is Suggest, returning early when the context is done
+	SuggestContext(ctx context.Context, prefix string, spaces []string, limit int) (protocol.SuggestResponse, error)
+}
+
+// NewSuggestAgent - SuggestAgent constructor
+func NewSuggestAgent(URLs []string, options ...Option) (SuggestAgent, error) {
+	agent := &suggestAgent{}
+	err := agent.connect(URLs, options)
+	if err != nil {
+		return nil, err
+	}
+	return agent, nil
+}
+
+type suggestAgent struct {
+	clusterAgent
+}
+
+func (agent *suggestAgent) Suggest(
+	prefix string, spaces []string, limit int,
+) (
+	res protocol.SuggestResponse,
+	err error,
+) {
+	return agent.SuggestContext(context.Background(), prefix, spaces, limit)
+}
+
+func (agent *suggestAgent) SuggestContext(
+	ctx context.Context, prefix string, spaces []string, limit int,
+) (
+	res protocol.SuggestResponse,
+	err error,
+) {
+	numShards, err := agent.getNumShards(ctx)
+	if err != nil {
+		return
+	}
+	req := protocol.SuggestRequest{
+		Spaces: spaces,
+		Prefix: prefix,
+		Limit:  uint16(limit),
+	}
+	ctx, cancel := agent.withTimeout(ctx)
+	defer cancel()
+	messages, err := agent.requestShards(ctx, "suggest", req, numShards, false)
+	if err != nil {
+		return
+	}
+	var responses []protocol.SuggestResponse
+	for _, message := range messages {
+		var response protocol.SuggestResponse
+		err = agent.conn.Enc.Decode(message.Subject, message.Data, &response)
+		if err != nil {
+			return
+		}
+		responses = append(responses, response)
+	}
+	return mergeSuggestions(responses, limit), nil
+}
+
+// mergeSuggestions sums the document counts of all shards per term.
+// Since each shard returns its own top terms, the merged counts
+// are lower bounds for terms missing from some shard's list.
+func mergeSuggestions(responses []protocol.SuggestResponse, limit int) protocol.SuggestResponse {
+	var merged protocol.SuggestResponse
+	counts := map[string]int{}
+	for _, response := range responses {
+		if merged.Duration < response.Duration {
+			merged.Duration = response.Duration
+		}
+		if merged.Status < response.Status {
+			merged.Status = response.Status
+		}
+		for _, suggestion := range response.Suggestions {
+			counts[suggestion.Term] += suggestion.Count
+		}
+	}
+
+	merged.Suggestions = []protocol.Suggestion{}
+	for term, count := range counts {
+		merged.Suggestions = append(merged.Suggestions, protocol.Suggestion{Term: term, Count: count})
+	}
+	sort.Slice(merged.Suggestions, func(i, j int) bool {
+		a, b := merged.Suggestions[i], merged.Suggestions[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Term < b.Term
+	})
+	if limit <= 0 {
+		limit = protocol.DefaultSuggestLimit
+	}
+	if len(merged.Suggestions) > limit {
+		merged.Suggestions = merged.Suggestions[:limit]
+	}
+	return merged
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CursorStart is passed as SearchRequest.After to start a cursor walk.
+// Cursor searches are not limited by the result cap, so a walk over all hits
+// must start with CursorStart instead of with a regular first page.
+const CursorStart = "start"
+
+// SearchCursor is the decoded form of the opaque search cursors
+// passed in SearchResult.Cursor and SearchRequest.After.
+// It holds the full sort key of a hit, which is the same on all shards.
+type SearchCursor struct {
+	Sort    SortOrder
+	Fuzzy   bool
+	Rank    float64
+	Updated time.Time
+	Space   string
+	ID      DocumentID
+	// Reference time of the search that produced the cursor
+	Now time.Time
+	// Number of hits following the cursor, as counted when the walk started
+	Remaining int
+}
+
+// NewSearchCursor creates an opaque cursor positioned at the given hit,
+// followed by the given number of remaining hits
+func NewSearchCursor(hit SearchHit, sort SortOrder, now time.Time, remaining int) string {
+	cursor := SearchCursor{
+		Sort:      sort,
+		Fuzzy:     hit.Fuzzy,
+		Rank:      hit.Rank,
+		Updated:   hit.Updated,
+		Space:     hit.Space,
+		ID:        hit.ID,
+		Now:       now,
+		Remaining: max(remaining, 0),
+	}
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// ParseSearchCursor decodes an opaque cursor
+func ParseSearchCursor(cursor string) (SearchCursor, error) {
+	var decoded SearchCursor
+	encoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return decoded, fmt.Errorf("malformed cursor: %w", err)
+	}
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		return decoded, fmt.Errorf("malformed cursor: %w", err)
+	}
+	return decoded, nil
+}
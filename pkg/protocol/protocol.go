@@ -24,7 +24,7 @@ import (
 )
 
 // Version of the wire protocol
-var Version = Semver{0, 5, 0}
+var Version = Semver{0, 6, 0}
 
 // DocumentID is just a string, could be uuid, hash, numeric, et.c.
 type DocumentID string
@@ -75,6 +75,26 @@ func (status IndexStatus) String() string {
 		status.DocCount, status.LastUpdate, status.Status)
 }
 
+// TermStatistics is regularly broadcast from workers in distributed
+// statistics mode, sharing the term statistics of their shard for
+// cluster wide ranking. Large statistics are split in chunks, sent
+// as separate messages.
+type TermStatistics struct {
+	// The shard, like "1/3"
+	Shard string
+	// When the statistics were read, shared by all chunks of an update
+	Updated   time.Time
+	Chunk     int
+	NumChunks int
+	// Number of documents in the shard
+	Documents int64
+	// Total number of tokens in all documents of the shard
+	Tokens int64
+	// Number of documents containing each term, for a chunk of the
+	// most common terms of the shard
+	Terms map[string]int64
+}
+
 // IndexUpdateRequest is a request for available updates.
 // Returns up to 'Limit' document IDs, updated at or later than
 // the specified document or timestamp.
@@ -105,6 +125,18 @@ type Document struct {
 	Title   string
 	Text    string
 	Alive   bool
+	// Static rank boost, subtracted from the rank of all hits
+	// for the document. Positive values move the document up.
+	Boost float32
+	// Keyword fields, like tags or authors, used for search filtering.
+	// Each field can hold several values.
+	Fields map[string][]string
+}
+
+// A DocumentLocator identifies a document in a space
+type DocumentLocator struct {
+	Space string
+	ID    DocumentID
 }
 
 // A DocumentUpdate is sent in response to DocumentRequest
@@ -133,6 +165,92 @@ type CloneStream struct {
 	URL string
 }
 
+// MatchMode controls how the terms of a query are combined
+type MatchMode uint8
+
+// Available match modes
+const (
+	// All terms must occur within MatchDistance tokens of each other
+	MatchProximity MatchMode = iota
+	// All terms must occur, anywhere in the document
+	MatchAll
+	// Any term must occur
+	MatchAny
+)
+
+func (mm MatchMode) String() string {
+	strings := map[MatchMode]string{
+		MatchProximity: "near",
+		MatchAll:       "all",
+		MatchAny:       "any",
+	}
+	str, found := strings[mm]
+	if !found {
+		return fmt.Sprintf("unknown (%d)", mm)
+	}
+	return str
+}
+
+// DefaultMatchDistance is the proximity match distance used
+// when no distance is given
+const DefaultMatchDistance = 15
+
+// SortOrder controls how search hits are ordered
+type SortOrder uint8
+
+// Available sort orders
+const (
+	// Most relevant first, by rank
+	SortByRank SortOrder = iota
+	// Most recently updated first
+	SortByDate
+	// Most relevant first, by rank boosted by recency
+	SortByRecency
+)
+
+func (so SortOrder) String() string {
+	strings := map[SortOrder]string{
+		SortByRank:    "rank",
+		SortByDate:    "date",
+		SortByRecency: "recency",
+	}
+	str, found := strings[so]
+	if !found {
+		return fmt.Sprintf("unknown (%d)", so)
+	}
+	return str
+}
+
+// SnippetMode controls what is returned as hit snippets
+type SnippetMode uint8
+
+// Available snippet modes
+const (
+	// Fragments of the matching text
+	SnippetText SnippetMode = iota
+	// The document title
+	SnippetTitle
+	// No snippet, for the least search work
+	SnippetNone
+)
+
+func (sm SnippetMode) String() string {
+	strings := map[SnippetMode]string{
+		SnippetText:  "text",
+		SnippetTitle: "title",
+		SnippetNone:  "none",
+	}
+	str, found := strings[sm]
+	if !found {
+		return fmt.Sprintf("unknown (%d)", sm)
+	}
+	return str
+}
+
+// DefaultSnippetTokens is the text snippet fragment length used
+// when no length is given
+const DefaultSnippetTokens = 10
+
 // A SearchRequest is sent from a search handler to search the index.
 type SearchRequest struct {
 	// Spaces to search
@@ -143,11 +261,75 @@ type SearchRequest struct {
 	PageLimit uint16
 	// Zero-indexed page of hits to retrieve
 	PageOffset uint16
-	// When true, spelling mistakes are "fixed"
-	// and the resulting query is automatically performed.
+	// When true, spelling mistakes of queries without matches
+	// are "fixed" and the resulting query is automatically performed.
 	// In either case, spell-fixed queries are returned
-	// in the SearchResult Respelt field.
+	// in the SearchResult Respelt and Corrections fields.
 	Autocorrect bool
+	// When true, all single term phrases are matched fuzzily,
+	// as if suffixed by the "~" operator, except in NEAR/n groups.
+	// Matches of the original terms sort before matches of
+	// their spelling neighbours.
+	Fuzzy bool
+	// How query terms are combined, defaults to proximity matching
+	MatchMode MatchMode
+	// Maximum distance in tokens between terms in proximity mode.
+	// Zero means DefaultMatchDistance.
+	MatchDistance uint16
+	// When set, only documents updated at or after this time are matched
+	UpdatedFrom time.Time
+	// When set, only documents updated before this time are matched
+	UpdatedTo time.Time
+	// When not zero, only documents updated within this duration
+	// before the time of the search are matched.
+	// Combined with UpdatedFrom, the later bound is used.
+	UpdatedWithin time.Duration
+	// Hit ordering, defaults to rank
+	Sort SortOrder
+	// Maximum recency boost added to the rank in SortByRecency mode.
+	// Zero means worker default.
+	RecencyWeight float32
+	// Document age at which the recency boost is halved.
+	// Zero means worker default.
+	RecencyHalfLife time.Duration
+	// Reference time for UpdatedWithin and recency boosts.
+	// Zero means the time the request is handled, or the time
+	// stored in the After cursor.
+	Now time.Time
+	// Cursor from a previous SearchResult, or CursorStart. When set,
+	// the hits following the cursor position are returned, PageOffset
+	// is ignored and the search is not limited by the result cap.
+	After string
+	// When true, search diagnostics are returned in
+	// SearchResponse.Explanations
+	Explain bool
+	// Time left before the client stops waiting for the response.
+	// Workers use it instead of their search timeout when shorter.
+	// Zero means no client deadline.
+	Timeout time.Duration
+	// Field filter expressions, which all must hold for a document
+	// to match. Supported forms are "field=value", "field!=value",
+	// "field in (a, b)" and "field not in (a, b)".
+	// Values containing commas or parentheses can be double quoted.
+	Filters []string
+	// When true, hits are counted per space in SearchResult.Facets
+	SpaceFacets bool
+	// Fields to count hits per value for in SearchResult.Facets
+	FieldFacets []string
+	// What to return as hit snippets
+	Snippet SnippetMode
+	// Number of tokens per text snippet fragment,
+	// DefaultSnippetTokens when zero
+	SnippetTokens uint16
+	// Max number of text snippet fragments, one when zero
+	SnippetFragments uint8
+	// Markers inserted around matched tokens in hit snippets and titles.
+	// Highlighting is enabled when any of them is set.
+	HighlightOpen  string
+	HighlightClose string
+	// Documents never returned as hits, like the source
+	// document of a similarity search
+	Exclude []DocumentLocator
 }
 
 // SearchResult is a collection of search hits
@@ -156,13 +338,50 @@ type SearchResult struct {
 	// When true, the search was truncated
 	// Capped results are only locally sorted by rank
 	Capped bool
-	// When not empty, the original query had no matches,
-	// and this is a respelt version of the query
+	// When not empty, the original query had no or few matches,
+	// and this is the best respelt version of the query
 	Respelt string
 	// The summed Levenshtein distance for all respelt terms
 	RespeltDistance float32
-	// The total number of hits to the given query
+	// Respelt versions of a query with no or few matches,
+	// finding more hits than the query, best first.
+	// Each shard validates and returns its closest correction only.
+	Corrections []Correction
+	// The total number of hits to the given query,
+	// or the number of hits after the cursor for cursor requests.
+	// Cursor walks count hits on the first page only, later pages
+	// get the count carried by the cursor.
 	TotalHits int
+	// Opaque cursor positioned at the last hit, to be passed as
+	// SearchRequest.After to get the following page.
+	// Empty when there are no hits.
+	Cursor string
+	// Requested facet counts
+	Facets SearchFacets
+	// When true, not all shards responded in time, and
+	// the result is merged from the responding shards only
+	Partial bool
+}
+
+// Correction is a respelt version of a query
+type Correction struct {
+	// Respelt query, in letarette syntax
+	Query string
+	// The summed Levenshtein distance for all respelt terms
+	Distance float32
+	// Expected number of hits to the respelt query.
+	// Merged from the shards suggesting the correction only,
+	// so the hits of other shards are not included.
+	Hits int
+}
+
+// SearchFacets holds hit counts per space and per field value,
+// counted over the same, possibly capped, set of hits as TotalHits.
+type SearchFacets struct {
+	// Hit count per space
+	Spaces map[string]int
+	// Hit count per value, for each requested field
+	Fields map[string]map[string]int
 }
 
 // SearchHit represents one search hit
@@ -170,7 +389,16 @@ type SearchHit struct {
 	Space   string
 	ID      DocumentID
 	Snippet string
-	Rank    float32
+	// Document title with highlighted matches,
+	// only set when highlighting is requested
+	Title string
+	// Lower is better, compare only hits from the same search.
+	// Full precision, since cursors are positioned by rank.
+	Rank    float64
+	Updated time.Time
+	// Set for fuzzy search hits only matching spelling neighbours
+	// of the query terms. Sorted after the other hits by rank.
+	Fuzzy bool
 }
 
 // SearchStatusCode is what is says
@@ -207,4 +435,140 @@ type SearchResponse struct {
 	Result   SearchResult
 	Duration float32
 	Status   SearchStatusCode
+	// Search diagnostics, one per shard.
+	// Only set for SearchRequests with Explain set.
+	Explanations []SearchExplanation
+	// Status of each shard taking part in the search
+	Shards []ShardStatus
+}
+
+// ShardStatus describes how one shard took part in a search
+type ShardStatus struct {
+	// The shard, like "1/3"
+	Shard string
+	// False when the shard did not respond in time
+	Responded bool
+	// Search status reported by the shard,
+	// SearchStatusTimeout when not responded
+	Status SearchStatusCode
+	// Search error reported by the shard, if any
+	Error string
+}
+
+// SearchBatchRequest is a list of independent search requests,
+// sent to all shards at once.
+type SearchBatchRequest struct {
+	Requests []SearchRequest
+}
+
+// MaxSearchBatchSize is the max number of requests in one batch
+const MaxSearchBatchSize = 100
+
+// SearchBatchResponse is sent in response to SearchBatchRequest,
+// with one response per request, in request order.
+type SearchBatchResponse struct {
+	Responses []SearchResponse
+	// Duration of the whole batch
+	Duration float32
+}
+
+// SearchExplanation describes how a search was performed by one shard
+type SearchExplanation struct {
+	// The shard that performed the search, like "1/3"
+	Shard string
+	// Phrases as parsed from the query string
+	Parsed []string
+	// Phrases left after reducing the parsed query
+	Reduced []string
+	// Canonical form of the reduced query, used for caching
+	Canonical string
+	// Query terms dropped as stopwords by the query tokenizer
+	Stopwords []string
+	// Synonyms added by the query tokenizer, per query term
+	Synonyms map[string][]string
+	// Spelling dictionary neighbours matched for fuzzy terms, per term
+	FuzzyTerms map[string][]string
+	// Spelling fixes, from original to fixed phrase
+	SpellFixes map[string]string
+	// When true, the respelt query was searched for
+	Autocorrected bool
+	// The FTS5 MATCH expression of the performed search
+	Match string
+	// The SQL search strategy used, depending on the snippet mode
+	Strategy int
+	// Time spent in each search stage, in order
+	Timings []StageTiming
+}
+
+// StageTiming is the time spent in one search stage
+type StageTiming struct {
+	Stage string
+	// Duration in seconds
+	Duration float32
+}
+
+// SuggestRequest is sent to get completions of a search term prefix
+type SuggestRequest struct {
+	// Spaces to count documents in, all spaces when empty
+	Spaces []string
+	// Term prefix to complete
+	Prefix string
+	// Max number of completions, DefaultSuggestLimit when zero
+	Limit uint16
+}
+
+// DefaultSuggestLimit is the number of completions returned
+// when no limit is given
+const DefaultSuggestLimit = 10
+
+// Suggestion is one completion of a term prefix
+type Suggestion struct {
+	// Indexed term
+	Term string
+	// Number of documents containing the term
+	Count int
+}
+
+// SuggestResponse is sent in response to SuggestRequest
+type SuggestResponse struct {
+	// Completions, most frequent first
+	Suggestions []Suggestion
+	Duration    float32
+	Status      SearchStatusCode
+}
+
+// SimilarRequest is sent to the shard owning a document, to get the
+// terms best describing it, for finding similar documents.
+type SimilarRequest struct {
+	Space string
+	ID    DocumentID
+	// Max number of terms, DefaultSimilarTerms when zero
+	Limit uint16
+}
+
+// DefaultSimilarTerms is the number of terms returned
+// when no limit is given
+const DefaultSimilarTerms = 25
+
+// SimilarResponse is sent in response to SimilarRequest
+type SimilarResponse struct {
+	// Indexed terms, most distinctive first
+	Terms    []string
+	Duration float32
+	Status   SearchStatusCode
+}
+
+// DocumentGetRequest is sent to the shard owning a document,
+// to fetch the document as stored in the index.
+type DocumentGetRequest struct {
+	Space string
+	ID    DocumentID
+}
+
+// DocumentGetResponse is sent in response to DocumentGetRequest.
+// The status is SearchStatusNoHit for documents not in the index.
+type DocumentGetResponse struct {
+	Document Document
+	Duration float32
+	Status   SearchStatusCode
 }
@@ -0,0 +1,39 @@
+// Copyright 2026 Erik Agsjö
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// ShardIndexFromDocumentID calculates a zero based shard index
+// based on a hash of the document ID.
+// The hash algorithm is chosen for even distribution in a shard group.
+func ShardIndexFromDocumentID(docID DocumentID, shardGroupSize int) int {
+	hasher := fnv.New32a()
+	_, _ = hasher.Write([]byte(docID))
+	sum := hasher.Sum(nil)
+	intPart := binary.BigEndian.Uint32(sum)
+	return int(intPart % uint32(shardGroupSize))
+}
+
+// ShardString formats a zero based shard index and a shard group size
+// as a shard setting, like "1/3" for the first of three shards.
+// Requests for a single shard are sent to subjects ending with it.
+func ShardString(shardIndex int, shardGroupSize int) string {
+	return fmt.Sprintf("%d/%d", shardIndex+1, shardGroupSize)
+}